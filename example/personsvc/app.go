@@ -14,23 +14,23 @@ import (
 )
 
 type PersonApp struct {
-	log *slog.Logger
+	migrations []store.Migration
+	log        *slog.Logger
 }
 
 // service.App is the interface defined by libsdk
 var _ service.App = &PersonApp{}
 
 // Migrations returns the app's DB migrations.
-func (p *PersonApp) Migrations() []string {
-	return personSvcMigrations()
+func (p *PersonApp) Migrations() []store.Migration {
+	return p.migrations
 }
 
 // Transactions returns the registered transactions available to the app.
+// Typed transactions like InsertPerson are registered with the store by main.
 func (p *PersonApp) Transactions() map[store.TxName]store.TxHandler {
 	txs := map[store.TxName]store.TxHandler{
-		InsertPerson: InsertPersonTx,
-		SelectPeople: SelectPeopleTx,
-		GetPerson:    GetPersonTx,
+		"InsertPerson": insertPersonV1,
 	}
 
 	return txs
@@ -62,7 +62,13 @@ func (p *PersonApp) insertHandler(store *store.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		rdm := rand.Intn(9999)
 
-		id, err := store.Exec(InsertPerson, "Rick", "Sanchez", fmt.Sprintf("rick%s@sanchez.com", strconv.Itoa(rdm)))
+		person := Person{
+			FirstName: "Rick",
+			LastName:  "Sanchez",
+			Email:     fmt.Sprintf("rick%s@sanchez.com", strconv.Itoa(rdm)),
+		}
+
+		id, err := InsertPerson.Exec(r.Context(), person)
 		if err != nil {
 			p.log.Error(errors.Wrap(err, "failed to Exec InsertPerson").Error())
 			w.WriteHeader(http.StatusInternalServerError)
@@ -77,15 +83,13 @@ func (p *PersonApp) getHandler(store *store.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := r.URL.Query().Get("id")
 
-		prs, err := store.Exec(GetPerson, id)
+		person, err := getPerson(r.Context(), store, id)
 		if err != nil {
-			p.log.Error(errors.Wrap(err, "failed to Exec GetPerson").Error())
+			p.log.Error(errors.Wrap(err, "failed to getPerson").Error())
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		person := prs.(*Person)
-
 		if err := resp.JSONOk(w, person); err != nil {
 			p.log.Error(errors.Wrap(err, "failed to resp.JSONOk").Error())
 			w.WriteHeader(http.StatusInternalServerError)
@@ -96,15 +100,13 @@ func (p *PersonApp) getHandler(store *store.Store) http.HandlerFunc {
 
 func (p *PersonApp) selectHandler(store *store.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ppl, err := store.Exec(SelectPeople)
+		people, err := selectPeople(r.Context(), store)
 		if err != nil {
-			p.log.Error(errors.Wrap(err, "failed to Exec GetPerson").Error())
+			p.log.Error(errors.Wrap(err, "failed to selectPeople").Error())
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		people := ppl.([]Person)
-
 		if err := resp.JSONOk(w, people); err != nil {
 			p.log.Error(errors.Wrap(err, "failed to resp.JSONOk").Error())
 			w.WriteHeader(http.StatusInternalServerError)
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
 	"log"
 	"log/slog"
+	"os"
+	"os/signal"
+	"time"
 
 	"github.com/cohix/libsdk/pkg/service"
 	"github.com/pkg/errors"
@@ -16,17 +20,44 @@ func main() {
 		log.Fatal(errors.Wrap(err, "failed to service.New"))
 	}
 
+	migrations, err := personSvcMigrations()
+	if err != nil {
+		log.Fatal(errors.Wrap(err, "failed to personSvcMigrations"))
+	}
+
+	// typed transactions are bound to the store they're registered with, so they can be executed without it
+	if err := InsertPerson.Register(svc.Store()); err != nil {
+		log.Fatal(errors.Wrap(err, "failed to Register InsertPerson"))
+	}
+
 	// an app is a type that returns everything that a service needs to operate
 	// such as migrations, registered transactions, public and private HTTP handlers, etc.
 	app := &PersonApp{
-		log: slog.With("app", "PERSON"),
+		migrations: migrations,
+		log:        slog.With("app", "PERSON"),
 	}
 
+	// when the process is interrupted, shut down the service gracefully,
+	// which stops the servers, the store's replay loop, and the fabric connection.
+	go func() {
+		sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		<-sigCtx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		if err := svc.Shutdown(shutdownCtx); err != nil {
+			slog.Error(errors.Wrap(err, "failed to svc.Shutdown").Error())
+		}
+	}()
+
 	slog.Info("starting PERSON service")
 
 	// calling Serve causes a few things to happen:
 	// 1) The fabric is started and waits for successful connection
-	// 2) The store initializes, runs all the migrations provided by the app
+	// 2) The store initializes, runs the migrations provided by the app that haven't been applied yet
 	//	  and then plays back all of the historical transactions from the fabric
 	// 3) Starts an HTTP server, handled by the app.Public() http.Handler
 	if err := svc.Serve(app); err != nil {
@@ -0,0 +1,6 @@
+CREATE TABLE people (
+	person_id INTEGER PRIMARY KEY,
+	first_name TEXT NOT NULL,
+	last_name TEXT NOT NULL,
+	email TEXT NOT NULL UNIQUE
+);
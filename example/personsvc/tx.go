@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"embed"
+
 	"github.com/cohix/libsdk/pkg/store"
 	"github.com/pkg/errors"
 )
@@ -12,23 +15,43 @@ type Person struct {
 	Email     string `db:"email" json:"email"`
 }
 
-func personSvcMigrations() []string {
-	return []string{m1}
+// migrations are embedded into the binary, and are applied in order of their file names.
+// Once a migration has been applied it must not be edited, so changes are made with new files.
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+func personSvcMigrations() ([]store.Migration, error) {
+	return store.MigrationsFS(migrations, "migrations")
+}
+
+// InsertPerson is an example of the best practice for defining a transaction handler. Defining it with
+// store.Define gives it a typed input and result, so callers can't pass the wrong args or mistake its result,
+// and a single variable makes Jump-To-Definition more useful and the codebase easier to reason about.
+// Its input is encoded differently to insertPersonV1's args, so it's the next version of the transaction.
+var InsertPerson = store.Define("InsertPerson@v2", insertPersonV2)
+
+func insertPersonV2(tx store.Tx, p Person) (int64, error) {
+	id, err := insertPerson(tx, p.FirstName, p.LastName, p.Email)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to insertPerson")
+	}
+
+	return id, nil
 }
 
-const m1 = `
-CREATE TABLE people (
-	person_id INTEGER PRIMARY KEY,
-	first_name TEXT NOT NULL,
-	last_name TEXT NOT NULL,
-	email TEXT NOT NULL UNIQUE
-);
-`
-
-// InsertPerson and InsertPersonTx are an example of the best practice for defining a transaction handler.
-// By defining a TxName variable and TxHandler func on the same line, it makes Jump-To-Definition more useful.
-// and the codebase easier to reason about. You can also define them on seperate lines if you prefer (see below).
-var InsertPerson, InsertPersonTx = store.TxName("InsertPerson"), func(tx store.Tx, args ...any) (any, error) {
+// insertPersonV1 is the first version of InsertPerson, which took the person's first name, last name and email
+// as args. It stays registered so that the records it executed can be replayed by new replicas.
+func insertPersonV1(tx store.Tx, args ...any) (any, error) {
+	id, err := insertPerson(tx, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to insertPerson")
+	}
+
+	return id, nil
+}
+
+func insertPerson(tx store.Tx, args ...any) (int64, error) {
 	q := `
 	INSERT INTO people (first_name, last_name, email)
 	VALUES($1, $2, $3);
@@ -36,17 +59,15 @@ var InsertPerson, InsertPersonTx = store.TxName("InsertPerson"), func(tx store.T
 
 	id, err := tx.ReadWrite().Exec(q, args...)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to Exec")
+		return 0, errors.Wrap(err, "failed to Exec")
 	}
 
 	return id, nil
 }
 
-// SelectPeople and SelectPeopleTx below is the more verbose two-line way to define transactions.
-// The above one-line version is preferable, but this is also acceptable!
-var SelectPeople = store.TxName("SelectPeople")
-
-func SelectPeopleTx(tx store.Tx, args ...any) (any, error) {
+// selectPeople and getPerson are reads, which don't need to be registered as transactions.
+// They're run against the local replica using store.Select and store.Get, and are never replicated.
+func selectPeople(ctx context.Context, s *store.Store) ([]Person, error) {
 	q := `
 	SELECT
 		person_id,
@@ -60,14 +81,14 @@ func SelectPeopleTx(tx store.Tx, args ...any) (any, error) {
 
 	ppl := []Person{}
 
-	if err := tx.Read().Select(&ppl, q, args...); err != nil {
-		return nil, errors.Wrap(err, "failed to Get")
+	if err := s.Select(ctx, &ppl, q); err != nil {
+		return nil, errors.Wrap(err, "failed to Select")
 	}
 
 	return ppl, nil
 }
 
-var GetPerson, GetPersonTx = store.TxName("GetPerson"), func(tx store.Tx, args ...any) (any, error) {
+func getPerson(ctx context.Context, s *store.Store, id string) (*Person, error) {
 	q := `
 	SELECT
 		person_id,
@@ -82,7 +103,7 @@ var GetPerson, GetPersonTx = store.TxName("GetPerson"), func(tx store.Tx, args .
 
 	person := &Person{}
 
-	if err := tx.Read().Get(person, q, args...); err != nil {
+	if err := s.Get(ctx, person, q, id); err != nil {
 		return nil, errors.Wrap(err, "failed to Get")
 	}
 
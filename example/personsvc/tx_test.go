@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	fabricmem "github.com/cohix/libsdk/pkg/fabric/fabric-mem"
+	"github.com/cohix/libsdk/pkg/store"
+	driversqlite "github.com/cohix/libsdk/pkg/store/driver-sqlite"
+)
+
+// startStore starts a PERSON store on the bus with the given transactions registered
+func startStore(t *testing.T, bus *fabricmem.Bus, register func(s *store.Store) error) *store.Store {
+	t.Helper()
+
+	replayer, err := fabricmem.NewWithBus("PERSON", bus).Replayer(context.Background(), "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	driver, err := driversqlite.NewWithOptions("PERSON", driversqlite.Options{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := store.NewWithOptions(driver, replayer, store.DefaultOptions())
+
+	if err := register(s); err != nil {
+		t.Fatal(err)
+	}
+
+	migrations, err := personSvcMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		s.Stop()
+	})
+
+	if err := s.Start(migrations); err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestInsertPersonReplaysV1(t *testing.T) {
+	ctx := context.Background()
+	bus := fabricmem.NewBus()
+
+	// a replica running the version of the service from before InsertPerson was typed
+	old := startStore(t, bus, func(s *store.Store) error {
+		return s.Register("InsertPerson", insertPersonV1)
+	})
+
+	if _, err := old.Exec("InsertPerson", "Rick", "Sanchez", "rick@sanchez.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// InsertPerson can only be registered with one store, so the test uses its own definition of it
+	insert := store.Define(InsertPerson.Name(), insertPersonV2)
+
+	s := startStore(t, bus, func(s *store.Store) error {
+		for name, handler := range (&PersonApp{}).Transactions() {
+			if err := s.Register(name, handler); err != nil {
+				return err
+			}
+		}
+
+		return insert.Register(s)
+	})
+
+	rick, err := getPerson(ctx, s, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rick.Email != "rick@sanchez.com" {
+		t.Fatalf("replayed person has email %s, want rick@sanchez.com", rick.Email)
+	}
+
+	id, err := insert.Exec(ctx, Person{FirstName: "Morty", LastName: "Smith", Email: "morty@smith.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id != 2 {
+		t.Fatalf("InsertPerson returned id %d, want 2", id)
+	}
+}
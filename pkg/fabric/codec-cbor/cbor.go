@@ -0,0 +1,43 @@
+package codeccbor
+
+import (
+	"github.com/cohix/libsdk/pkg/fabric"
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Codec encodes messages as CBOR (RFC 8949). Struct fields use
+// their `cbor` tags, falling back to their `json` tags.
+var Codec fabric.Codec = &CBOR{}
+
+// encMode encodes times as RFC 3339 strings with nanoseconds, as the
+// default of whole Unix seconds would lose the rest of their precision
+var encMode cbor.EncMode
+
+func init() {
+	mode, err := cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+
+	encMode = mode
+
+	fabric.RegisterCodec(Codec)
+}
+
+// CBOR is a CBOR codec for the fabric
+type CBOR struct{}
+
+// Name returns the codec's name
+func (c *CBOR) Name() string {
+	return "cbor"
+}
+
+// Marshal encodes v as CBOR
+func (c *CBOR) Marshal(v any) ([]byte, error) {
+	return encMode.Marshal(v)
+}
+
+// Unmarshal decodes CBOR data into v
+func (c *CBOR) Unmarshal(data []byte, v any) error {
+	return cbor.Unmarshal(data, v)
+}
@@ -0,0 +1,46 @@
+package codecmsgpack
+
+import (
+	"bytes"
+
+	"github.com/cohix/libsdk/pkg/fabric"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec encodes messages as MessagePack. Struct fields use their
+// `json` tags so that messages match their JSON equivalents.
+var Codec fabric.Codec = &Msgpack{}
+
+func init() {
+	fabric.RegisterCodec(Codec)
+}
+
+// Msgpack is a MessagePack codec for the fabric
+type Msgpack struct{}
+
+// Name returns the codec's name
+func (m *Msgpack) Name() string {
+	return "msgpack"
+}
+
+// Marshal encodes v as MessagePack
+func (m *Msgpack) Marshal(v any) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	enc := msgpack.NewEncoder(buf)
+	enc.SetCustomStructTag("json")
+
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes MessagePack data into v
+func (m *Msgpack) Unmarshal(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+
+	return dec.Decode(v)
+}
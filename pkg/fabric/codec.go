@@ -0,0 +1,73 @@
+package fabric
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// CodecHeader is the message header used to record which codec encoded a message,
+// allowing streams containing messages from several codecs to be read.
+const CodecHeader = "Libsdk-Codec"
+
+// Codec marshals and unmarshals messages sent over the fabric
+type Codec interface {
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSON is the default codec
+var JSON Codec = &jsonCodec{}
+
+var codecs = map[string]Codec{}
+var codecsLock = sync.RWMutex{}
+
+func init() {
+	RegisterCodec(JSON)
+}
+
+// RegisterCodec makes a codec available by name to fabrics decoding messages.
+// Codec packages register themselves when imported.
+func RegisterCodec(codec Codec) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+
+	codecs[codec.Name()] = codec
+}
+
+// CodecByName returns the registered codec with the given name.
+// An empty name returns JSON, as messages without a recorded codec predate codec support.
+func CodecByName(name string) (Codec, error) {
+	if name == "" {
+		return JSON, nil
+	}
+
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+
+	codec, exists := codecs[name]
+	if !exists {
+		return nil, fmt.Errorf("codec %s is not registered", name)
+	}
+
+	return codec, nil
+}
+
+// jsonCodec encodes messages using encoding/json
+type jsonCodec struct{}
+
+// Name returns the codec's name
+func (j *jsonCodec) Name() string {
+	return "json"
+}
+
+// Marshal encodes v as JSON
+func (j *jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes JSON data into v
+func (j *jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
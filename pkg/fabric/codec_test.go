@@ -0,0 +1,92 @@
+package fabric_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cohix/libsdk/pkg/fabric"
+	codeccbor "github.com/cohix/libsdk/pkg/fabric/codec-cbor"
+	codecmsgpack "github.com/cohix/libsdk/pkg/fabric/codec-msgpack"
+)
+
+// request resembles the messages sent over the fabric, such as private requests
+type request struct {
+	Method string         `json:"method"`
+	Header http.Header    `json:"header"`
+	Body   []byte         `json:"body"`
+	Time   time.Time      `json:"time"`
+	Tags   []string       `json:"tags"`
+	Meta   map[string]int `json:"meta"`
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	in := request{
+		Method: http.MethodPost,
+		Header: http.Header{"Content-Type": {"application/json"}, "X-Tags": {"a", "b"}},
+		Body:   []byte(`{"name":"rick"}`),
+		Time:   time.Date(2024, 3, 14, 15, 9, 26, 535897932, time.UTC),
+		Tags:   []string{"portal", "gun"},
+		Meta:   map[string]int{"dimension": 137},
+	}
+
+	for _, codec := range []fabric.Codec{fabric.JSON, codeccbor.Codec, codecmsgpack.Codec} {
+		registered, err := fabric.CodecByName(codec.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if registered != codec {
+			t.Fatalf("codec registered as %s is %T, want %T", codec.Name(), registered, codec)
+		}
+
+		data, err := codec.Marshal(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out := request{}
+
+		if err := codec.Unmarshal(data, &out); err != nil {
+			t.Fatal(err)
+		}
+
+		if !out.Time.Equal(in.Time) {
+			t.Fatalf("%s decoded time %v, want %v", codec.Name(), out.Time, in.Time)
+		}
+
+		out.Time = in.Time
+
+		if !reflect.DeepEqual(out, in) {
+			t.Fatalf("%s decoded %+v, want %+v", codec.Name(), out, in)
+		}
+
+		// fields are named by their json tags, so messages match their JSON equivalents
+		fields := map[string]any{}
+
+		if err := codec.Unmarshal(data, &fields); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, exists := fields["method"]; !exists {
+			t.Fatalf("%s encoded fields %v, want them named by json tags", codec.Name(), reflect.ValueOf(fields).MapKeys())
+		}
+	}
+}
+
+func TestCodecByName(t *testing.T) {
+	// messages without a recorded codec predate codecs, and were encoded as JSON
+	codec, err := fabric.CodecByName("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if codec != fabric.JSON {
+		t.Fatalf("CodecByName returned %s for no name, want json", codec.Name())
+	}
+
+	if _, err := fabric.CodecByName("protobuf"); err == nil {
+		t.Fatal("CodecByName returned an unregistered codec")
+	}
+}
@@ -0,0 +1,130 @@
+package fabricmem
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/cohix/libsdk/pkg/fabric"
+	"github.com/pkg/errors"
+)
+
+// BroadcastConnection is a connection for fan-out messaging
+type BroadcastConnection struct {
+	log       slog.Logger
+	bus       *Bus
+	codec     fabric.Codec
+	subject   string
+	lock      sync.Mutex
+	receivers []*broadcastReceiver
+}
+
+// broadcastReceiver is a receiver registered with Subscribe
+type broadcastReceiver struct {
+	gen      fabric.Generator
+	receiver fabric.Receiver
+}
+
+// Broadcaster returns a connection for fan-out messaging on subject
+func (m *Mem) Broadcaster(ctx context.Context, subject string) (fabric.BroadcastConnection, error) {
+	b := &BroadcastConnection{
+		log:     *slog.With("lib", "libsdk", "pkg", "fabricmem"),
+		bus:     m.bus,
+		codec:   m.codec,
+		subject: fmt.Sprintf("%s.bcast.%s", m.serviceName, subject),
+	}
+
+	return b, nil
+}
+
+// Broadcast passes msg to every receiver subscribed to the connection's subject
+func (b *BroadcastConnection) Broadcast(ctx context.Context, msg any) error {
+	m, err := encode(b.codec, msg)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode")
+	}
+
+	for _, r := range b.bus.broadcastReceivers(b.subject) {
+		obj := r.gen()
+
+		if err := decode(m, obj); err != nil {
+			b.log.Error(errors.Wrap(err, "failed to decode").Error())
+			continue
+		}
+
+		// receivers are called asynchronously, as they would be over a network
+		go func(r *broadcastReceiver) {
+			if err := r.receiver(obj); err != nil {
+				b.log.Error(errors.Wrap(err, "failed to receive broadcast message").Error())
+			}
+		}(r)
+	}
+
+	return nil
+}
+
+// Subscribe registers receiver for the connection's subject
+func (b *BroadcastConnection) Subscribe(ctx context.Context, gen fabric.Generator, receiver fabric.Receiver) error {
+	r := &broadcastReceiver{
+		gen:      gen,
+		receiver: receiver,
+	}
+
+	b.bus.addBroadcastReceiver(b.subject, r)
+
+	b.lock.Lock()
+	b.receivers = append(b.receivers, r)
+	b.lock.Unlock()
+
+	context.AfterFunc(ctx, func() {
+		b.bus.removeBroadcastReceiver(b.subject, r)
+	})
+
+	return nil
+}
+
+// Close removes all of the connection's receivers
+func (b *BroadcastConnection) Close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for _, r := range b.receivers {
+		b.bus.removeBroadcastReceiver(b.subject, r)
+	}
+
+	b.receivers = nil
+
+	return nil
+}
+
+// broadcastReceivers returns the receivers subscribed to subject
+func (b *Bus) broadcastReceivers(subject string) []*broadcastReceiver {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return append([]*broadcastReceiver{}, b.broadcasts[subject]...)
+}
+
+// addBroadcastReceiver subscribes a receiver to subject
+func (b *Bus) addBroadcastReceiver(subject string, r *broadcastReceiver) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.broadcasts[subject] = append(b.broadcasts[subject], r)
+}
+
+// removeBroadcastReceiver unsubscribes a receiver from subject
+func (b *Bus) removeBroadcastReceiver(subject string, r *broadcastReceiver) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	receivers := b.broadcasts[subject]
+
+	for i := range receivers {
+		if receivers[i] == r {
+			b.broadcasts[subject] = append(receivers[:i:i], receivers[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package fabricmem
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cohix/libsdk/pkg/fabric"
+	"github.com/pkg/errors"
+)
+
+// deadLetters holds the dead lettered messages for a service
+type deadLetters struct {
+	lock    sync.Mutex
+	nextID  uint64
+	letters []fabric.DeadLetter
+}
+
+// deliver passes m to recv, retrying with a backoff if it fails and dead lettering it
+// once it has failed maxDeliveries times. It returns false if the connection was closed.
+func (r *ReplayConnection) deliver(m *message, seq uint64, gen fabric.Generator, recv fabric.ReplayReceiver) bool {
+	for attempt := 1; ; attempt++ {
+		obj := gen()
+
+		err := decode(m, obj)
+		if err == nil {
+			err = recv(seq, obj)
+		}
+
+		if err == nil {
+			return true
+		}
+
+		if attempt >= maxDeliveries {
+			r.log.Error("replayed message failed repeatedly, dead lettering", "seq", seq, "err", err.Error())
+			r.dlq.add(r.stream.subject, r.instance, seq, m, err)
+
+			return true
+		}
+
+		r.log.Warn("replayed message failed, redelivering", "seq", seq, "attempt", attempt, "err", err.Error())
+
+		select {
+		case <-time.After(redeliveryBackoff * time.Duration(attempt)):
+		case <-r.ctx.Done():
+			return false
+		}
+	}
+}
+
+// DeadLetters lists the messages from the connection's subject that this instance dead lettered
+func (r *ReplayConnection) DeadLetters(ctx context.Context) ([]fabric.DeadLetter, error) {
+	return r.dlq.list(r.stream.subject, r.instance), nil
+}
+
+// Redrive delivers the dead lettered message with the given ID to the connection's receiver,
+// and removes it from the dead letters if the receiver succeeds
+func (r *ReplayConnection) Redrive(ctx context.Context, id uint64) error {
+	r.lock.Lock()
+	gen, recv := r.gen, r.recv
+	r.lock.Unlock()
+
+	if recv == nil {
+		return errors.New("cannot Redrive before Replay has started")
+	}
+
+	letter, exists := r.dlq.get(id)
+	if !exists || letter.Subject != r.stream.subject {
+		return fmt.Errorf("message %d is not a dead letter for %s", id, r.stream.subject)
+	}
+
+	if letter.Instance != r.instance {
+		return fmt.Errorf("message %d was dead lettered by instance %q, not this instance %q", id, letter.Instance, r.instance)
+	}
+
+	obj := gen()
+
+	if err := letter.Decode(obj); err != nil {
+		return errors.Wrap(err, "failed to Decode")
+	}
+
+	if err := recv(letter.Sequence, obj); err != nil {
+		return errors.Wrap(err, "failed to receive redriven message")
+	}
+
+	r.dlq.remove(id)
+
+	return nil
+}
+
+// instance returns a unique instance ID for a new fabric of the service
+func (b *Bus) instance(serviceName string) string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.instances++
+
+	return fmt.Sprintf("%s-%d", serviceName, b.instances)
+}
+
+// dlq returns the dead letters for the service, creating them if needed
+func (b *Bus) dlq(serviceName string) *deadLetters {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	d, exists := b.dlqs[serviceName]
+	if !exists {
+		d = &deadLetters{
+			letters: []fabric.DeadLetter{},
+		}
+
+		b.dlqs[serviceName] = d
+	}
+
+	return d
+}
+
+// add dead letters a message
+func (d *deadLetters) add(subject, instance string, seq uint64, m *message, err error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.nextID++
+
+	letter := fabric.DeadLetter{
+		ID:       d.nextID,
+		Subject:  subject,
+		Instance: instance,
+		Sequence: seq,
+		Error:    err.Error(),
+		Time:     time.Now(),
+		Codec:    m.codec,
+		Data:     m.body,
+	}
+
+	d.letters = append(d.letters, letter)
+}
+
+// list returns the dead letters for subject from instance
+func (d *deadLetters) list(subject, instance string) []fabric.DeadLetter {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	letters := []fabric.DeadLetter{}
+
+	for _, l := range d.letters {
+		if l.Subject == subject && l.Instance == instance {
+			letters = append(letters, l)
+		}
+	}
+
+	return letters
+}
+
+// get returns the dead letter with the given ID
+func (d *deadLetters) get(id uint64) (fabric.DeadLetter, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for _, l := range d.letters {
+		if l.ID == id {
+			return l, true
+		}
+	}
+
+	return fabric.DeadLetter{}, false
+}
+
+// remove deletes the dead letter with the given ID
+func (d *deadLetters) remove(id uint64) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for i, l := range d.letters {
+		if l.ID == id {
+			d.letters = append(d.letters[:i:i], d.letters[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,498 @@
+package fabricmem
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/cohix/libsdk/pkg/fabric"
+	"github.com/pkg/errors"
+)
+
+// msgTimeout is the maximum time SendAndRecv waits for a reply
+const msgTimeout = time.Second * 10
+
+// a replayed message that fails is redelivered up to maxDeliveries times, waiting
+// redeliveryBackoff multiplied by the number of attempts so far, before being dead lettered
+const maxDeliveries = 5
+const redeliveryBackoff = time.Millisecond * 10
+
+// messages published with the ID of a message published within duplicateWindow are discarded
+const duplicateWindow = time.Minute * 2
+
+// defaultBus is shared by all fabrics created with New, connecting every service in the process
+var defaultBus = NewBus()
+
+var _ fabric.Fabric = &Mem{}
+var _ fabric.Deduplicator = &ReplayConnection{}
+
+// Mem is an in-process fabric, useful for tests and single-process applications.
+// Replayed messages are ordered and durable for the lifetime of the Bus.
+type Mem struct {
+	serviceName string
+	bus         *Bus
+	codec       fabric.Codec
+	instance    string
+}
+
+// Bus holds the streams and message handlers for a set of in-process fabrics.
+// Fabrics created with the same Bus can communicate with each other.
+type Bus struct {
+	lock       sync.Mutex
+	streams    map[string]*stream
+	handlers   map[string][]*msgHandler
+	next       map[string]int
+	dlqs       map[string]*deadLetters
+	snapshots  map[string]*SnapshotStore
+	broadcasts map[string][]*broadcastReceiver
+	instances  int
+}
+
+// stream is an ordered, append-only list of messages for a subject
+type stream struct {
+	subject string
+	lock    sync.Mutex
+	msgs    []*message
+	ids     map[string]time.Time
+	notify  chan struct{}
+}
+
+// message is an encoded message and the name of the codec that encoded it
+type message struct {
+	codec string
+	body  []byte
+}
+
+// msgHandler is a handler registered with RecvAndReply
+type msgHandler struct {
+	gen     fabric.Generator
+	handler fabric.Handler
+}
+
+// MsgConnection is a connection for request/reply messaging
+type MsgConnection struct {
+	log      slog.Logger
+	bus      *Bus
+	codec    fabric.Codec
+	subject  string
+	lock     sync.Mutex
+	handlers []*msgHandler
+}
+
+// ReplayConnection is a connection for pub/sub/replay
+type ReplayConnection struct {
+	log      slog.Logger
+	stream   *stream
+	dlq      *deadLetters
+	instance string
+	codec    fabric.Codec
+	ctx      context.Context
+	cancel   context.CancelFunc
+	lock     sync.Mutex
+	gen      fabric.Generator
+	recv     fabric.ReplayReceiver
+}
+
+// NewBus creates an empty Bus
+func NewBus() *Bus {
+	b := &Bus{
+		streams:    map[string]*stream{},
+		handlers:   map[string][]*msgHandler{},
+		next:       map[string]int{},
+		dlqs:       map[string]*deadLetters{},
+		snapshots:  map[string]*SnapshotStore{},
+		broadcasts: map[string][]*broadcastReceiver{},
+	}
+
+	return b
+}
+
+// New creates a new in-memory fabric connected to the process-wide default Bus
+func New(serviceName string) (*Mem, error) {
+	return NewWithBus(serviceName, defaultBus), nil
+}
+
+// NewWithBus creates a new in-memory fabric connected to the provided Bus
+func NewWithBus(serviceName string, bus *Bus) *Mem {
+	return NewWithCodec(serviceName, bus, fabric.JSON)
+}
+
+// NewWithCodec creates a new in-memory fabric connected to the provided Bus that encodes
+// messages with the provided codec. Messages are decoded with the codec that encoded them.
+// Each fabric is a separate instance of the service, with its own dead letters.
+func NewWithCodec(serviceName string, bus *Bus, codec fabric.Codec) *Mem {
+	m := &Mem{
+		serviceName: serviceName,
+		bus:         bus,
+		codec:       codec,
+		instance:    bus.instance(serviceName),
+	}
+
+	return m
+}
+
+// Messenger returns a connection for message sending/receiving
+func (m *Mem) Messenger(service string) (fabric.MsgConnection, error) {
+	c := &MsgConnection{
+		log:     *slog.With("lib", "libsdk", "pkg", "fabricmem"),
+		bus:     m.bus,
+		codec:   m.codec,
+		subject: fmt.Sprintf("%s.msg", service),
+	}
+
+	return c, nil
+}
+
+// Replayer returns a connection for Replayer publish/replay
+func (m *Mem) Replayer(ctx context.Context, subject string) (fabric.ReplayConnection, error) {
+	s := m.bus.stream(fmt.Sprintf("%s.%s", m.serviceName, subject))
+
+	// the connection's context is independent of ctx, which is only
+	// scoped to creating the connection, and is cancelled by Close
+	replayCtx, cancel := context.WithCancel(context.Background())
+
+	r := &ReplayConnection{
+		log:      *slog.With("lib", "libsdk", "pkg", "fabricmem"),
+		stream:   s,
+		dlq:      m.bus.dlq(m.serviceName),
+		instance: m.instance,
+		codec:    m.codec,
+		ctx:      replayCtx,
+		cancel:   cancel,
+	}
+
+	return r, nil
+}
+
+// Close is a no-op, as the fabric's state belongs to its Bus
+func (m *Mem) Close() error {
+	return nil
+}
+
+// SendAndRecv sends a message to the connection's service and passes the reply to receiver
+func (c *MsgConnection) SendAndRecv(ctx context.Context, msg any, gen fabric.Generator, receiver fabric.Receiver) error {
+	req, err := encode(c.codec, msg)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode")
+	}
+
+	h := c.bus.handler(c.subject)
+	if h == nil {
+		return fmt.Errorf("no responders available for %s", c.subject)
+	}
+
+	obj := h.gen()
+
+	if err := decode(req, obj); err != nil {
+		return errors.Wrap(err, "failed to decode")
+	}
+
+	replies := make(chan *message, 1)
+
+	replier := func(reply any) {
+		resp, err := encode(c.codec, reply)
+		if err != nil {
+			c.log.Error(errors.Wrap(err, "failed to encode reply").Error())
+			return
+		}
+
+		select {
+		case replies <- resp:
+		default:
+			c.log.Warn("dropping additional reply", "subject", c.subject)
+		}
+	}
+
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, msgTimeout)
+		defer cancel()
+	}
+
+	go h.handler(ctx, obj, replier)
+
+	select {
+	case resp := <-replies:
+		replyObj := gen()
+
+		if err := decode(resp, replyObj); err != nil {
+			return errors.Wrap(err, "failed to decode reply")
+		}
+
+		return receiver(replyObj)
+	case <-ctx.Done():
+		return errors.Wrapf(ctx.Err(), "failed waiting for reply from %s", c.subject)
+	}
+}
+
+// RecvAndReply registers handler for the connection's service. When several
+// handlers are registered for a service, messages are load balanced amongst them.
+func (c *MsgConnection) RecvAndReply(ctx context.Context, gen fabric.Generator, handler fabric.Handler) error {
+	h := &msgHandler{
+		gen:     gen,
+		handler: handler,
+	}
+
+	c.bus.addHandler(c.subject, h)
+
+	c.lock.Lock()
+	c.handlers = append(c.handlers, h)
+	c.lock.Unlock()
+
+	context.AfterFunc(ctx, func() {
+		c.bus.removeHandler(c.subject, h)
+	})
+
+	return nil
+}
+
+// Close removes all of the connection's handlers
+func (c *MsgConnection) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, h := range c.handlers {
+		c.bus.removeHandler(c.subject, h)
+	}
+
+	c.handlers = nil
+
+	return nil
+}
+
+// Publish publishes a message to a broadcast channel. Messages that are fabric.Identifiers
+// are discarded if a message with the same ID was published within the duplicate window.
+func (r *ReplayConnection) Publish(ctx context.Context, msg any) error {
+	m, err := encode(r.codec, msg)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode")
+	}
+
+	id := ""
+	if identified, ok := msg.(fabric.Identifier); ok {
+		id = identified.MessageID()
+	}
+
+	r.stream.append(id, m)
+
+	return nil
+}
+
+// DuplicateWindow returns how long message IDs are remembered for
+func (r *ReplayConnection) DuplicateWindow() time.Duration {
+	return duplicateWindow
+}
+
+// Replay delivers every message from the given position, in order, to recv. A message's
+// sequence is its position in the subject, starting at 1. The returned channel fires once
+// all of the messages that existed when Replay was called have been delivered, and replay
+// continues with new messages after that. Replay stops when ctx is cancelled or the connection is closed.
+func (r *ReplayConnection) Replay(ctx context.Context, from fabric.Position, gen fabric.Generator, recv fabric.ReplayReceiver) (chan bool, error) {
+	r.stream.lock.Lock()
+	length := len(r.stream.msgs)
+	r.stream.lock.Unlock()
+
+	start := 0
+
+	switch {
+	case from.Now:
+		start = length
+	case from.After > 0:
+		start = int(min(from.After, uint64(length)))
+	}
+
+	pending := length - start
+
+	upToChan := make(chan bool, 1)
+	upToOnce := sync.Once{}
+	upToCounter := 0
+
+	upToCompletion := func() {
+		upToOnce.Do(func() {
+			upToChan <- true
+		})
+	}
+
+	// if there is nothing to replay, notify now
+	if pending == 0 {
+		upToCompletion()
+	}
+
+	r.lock.Lock()
+	r.gen = gen
+	r.recv = recv
+	r.lock.Unlock()
+
+	stopAfter := context.AfterFunc(ctx, r.cancel)
+
+	go func() {
+		defer stopAfter()
+
+		for i := start; ; i++ {
+			m, ok := r.stream.wait(r.ctx, i)
+			if !ok {
+				return
+			}
+
+			if !r.deliver(m, uint64(i+1), gen, recv) {
+				return
+			}
+
+			upToCounter++
+
+			if upToCounter >= pending {
+				upToCompletion()
+			}
+		}
+	}()
+
+	return upToChan, nil
+}
+
+// Close stops replaying messages
+func (r *ReplayConnection) Close() error {
+	r.cancel()
+
+	return nil
+}
+
+// stream returns the stream for the subject, creating it if needed
+func (b *Bus) stream(subject string) *stream {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	s, exists := b.streams[subject]
+	if !exists {
+		s = &stream{
+			subject: subject,
+			msgs:    []*message{},
+			ids:     map[string]time.Time{},
+			notify:  make(chan struct{}),
+		}
+
+		b.streams[subject] = s
+	}
+
+	return s
+}
+
+// handler returns the next handler for the subject in round-robin order, or nil if there are none
+func (b *Bus) handler(subject string) *msgHandler {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	handlers := b.handlers[subject]
+	if len(handlers) == 0 {
+		return nil
+	}
+
+	idx := b.next[subject] % len(handlers)
+	b.next[subject] = idx + 1
+
+	return handlers[idx]
+}
+
+// addHandler registers a handler for the subject
+func (b *Bus) addHandler(subject string, h *msgHandler) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.handlers[subject] = append(b.handlers[subject], h)
+}
+
+// removeHandler unregisters a handler for the subject
+func (b *Bus) removeHandler(subject string, h *msgHandler) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	handlers := b.handlers[subject]
+
+	for i := range handlers {
+		if handlers[i] == h {
+			b.handlers[subject] = append(handlers[:i:i], handlers[i+1:]...)
+			return
+		}
+	}
+}
+
+// append adds a message to the stream and wakes any waiting consumers,
+// unless id is set and a message with the same id was recently appended
+func (s *stream) append(id string, m *message) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if id != "" {
+		now := time.Now()
+
+		for existing, published := range s.ids {
+			if now.Sub(published) > duplicateWindow {
+				delete(s.ids, existing)
+			}
+		}
+
+		if _, exists := s.ids[id]; exists {
+			return
+		}
+
+		s.ids[id] = now
+	}
+
+	s.msgs = append(s.msgs, m)
+
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+// wait returns the message at index i, blocking until it exists or ctx is done
+func (s *stream) wait(ctx context.Context, i int) (*message, bool) {
+	for {
+		s.lock.Lock()
+
+		if i < len(s.msgs) {
+			m := s.msgs[i]
+			s.lock.Unlock()
+
+			return m, true
+		}
+
+		notify := s.notify
+		s.lock.Unlock()
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// encode marshals obj with codec, recording the codec's name alongside the message
+func encode(codec fabric.Codec, obj any) (*message, error) {
+	body, err := codec.Marshal(obj)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to %s Marshal", codec.Name())
+	}
+
+	m := &message{
+		codec: codec.Name(),
+		body:  body,
+	}
+
+	return m, nil
+}
+
+// decode unmarshals the message into obj using the codec that encoded it
+func decode(m *message, obj any) error {
+	codec, err := fabric.CodecByName(m.codec)
+	if err != nil {
+		return errors.Wrap(err, "failed to CodecByName")
+	}
+
+	if err := codec.Unmarshal(m.body, obj); err != nil {
+		return errors.Wrapf(err, "failed to %s Unmarshal", codec.Name())
+	}
+
+	return nil
+}
@@ -0,0 +1,196 @@
+package fabricmem
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cohix/libsdk/pkg/fabric"
+	codeccbor "github.com/cohix/libsdk/pkg/fabric/codec-cbor"
+	codecmsgpack "github.com/cohix/libsdk/pkg/fabric/codec-msgpack"
+)
+
+// msg is a replayed message with an ID
+type msg struct {
+	ID string `json:"id"`
+}
+
+// MessageID returns the message's ID
+func (m msg) MessageID() string {
+	return m.ID
+}
+
+// replay replays the subject on a new fabric for the service, passing messages to recv
+func replay(t *testing.T, bus *Bus, recv fabric.ReplayReceiver) fabric.ReplayConnection {
+	t.Helper()
+
+	r, err := NewWithBus("svc", bus).Replayer(context.Background(), "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		r.Close()
+	})
+
+	gen := func() any {
+		return &msg{}
+	}
+
+	upToDate, err := r.Replay(context.Background(), fabric.FromBeginning, gen, recv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-upToDate
+
+	return r
+}
+
+func TestReplayDiscardsDuplicates(t *testing.T) {
+	ctx := context.Background()
+	bus := NewBus()
+
+	received := make(chan string, 10)
+
+	r := replay(t, bus, func(seq uint64, m any) error {
+		received <- m.(*msg).ID
+		return nil
+	})
+
+	for _, id := range []string{"a", "b", "a"} {
+		if err := r.Publish(ctx, msg{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, want := range []string{"a", "b"} {
+		select {
+		case id := <-received:
+			if id != want {
+				t.Fatalf("received %s, want %s", id, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %s", want)
+		}
+	}
+
+	select {
+	case id := <-received:
+		t.Fatalf("received duplicate %s", id)
+	case <-time.After(time.Millisecond * 50):
+	}
+}
+
+func TestDeadLettersScopedToInstance(t *testing.T) {
+	ctx := context.Background()
+	bus := NewBus()
+
+	healthy := replay(t, bus, func(seq uint64, m any) error {
+		return nil
+	})
+
+	fixed := make(chan bool, 1)
+	applied := make(chan string, 1)
+
+	failing := replay(t, bus, func(seq uint64, m any) error {
+		select {
+		case <-fixed:
+		default:
+			return errors.New("bug")
+		}
+
+		applied <- m.(*msg).ID
+
+		return nil
+	})
+
+	if err := healthy.Publish(ctx, msg{ID: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var letters []fabric.DeadLetter
+
+	deadline := time.Now().Add(time.Second * 5)
+
+	for len(letters) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for dead letter")
+		}
+
+		time.Sleep(time.Millisecond * 10)
+
+		l, err := failing.DeadLetters(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		letters = l
+	}
+
+	healthyLetters, err := healthy.DeadLetters(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(healthyLetters) != 0 {
+		t.Fatalf("healthy instance lists dead letters %+v, want none", healthyLetters)
+	}
+
+	if err := healthy.Redrive(ctx, letters[0].ID); err == nil {
+		t.Fatal("healthy instance redrove another instance's dead letter")
+	}
+
+	fixed <- true
+
+	if err := failing.Redrive(ctx, letters[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if id := <-applied; id != "a" {
+		t.Fatalf("redrove %s, want a", id)
+	}
+
+	remaining, err := failing.DeadLetters(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(remaining) != 0 {
+		t.Fatalf("dead letters %+v remain after redriving, want none", remaining)
+	}
+}
+
+func TestMixedCodecs(t *testing.T) {
+	ctx := context.Background()
+	bus := NewBus()
+
+	received := make(chan string, 10)
+
+	// the replaying fabric encodes with JSON, and decodes each message with the codec that encoded it
+	replay(t, bus, func(seq uint64, m any) error {
+		received <- m.(*msg).ID
+		return nil
+	})
+
+	for _, codec := range []fabric.Codec{codeccbor.Codec, codecmsgpack.Codec} {
+		r, err := NewWithCodec("svc", bus, codec).Replayer(ctx, "store")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := r.Publish(ctx, msg{ID: codec.Name()}); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case id := <-received:
+			if id != codec.Name() {
+				t.Fatalf("received %s, want %s", id, codec.Name())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for message encoded with %s", codec.Name())
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package fabricmem
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/cohix/libsdk/pkg/fabric"
+	"github.com/pkg/errors"
+)
+
+var _ fabric.SnapshotStore = &SnapshotStore{}
+
+// SnapshotStore holds the latest snapshot for a subject in memory
+type SnapshotStore struct {
+	lock sync.Mutex
+	snap *fabric.Snapshot
+	data []byte
+}
+
+// Snapshots returns the snapshot store for subject
+func (m *Mem) Snapshots(ctx context.Context, subject string) (fabric.SnapshotStore, error) {
+	name := fmt.Sprintf("%s.%s", m.serviceName, subject)
+
+	m.bus.lock.Lock()
+	defer m.bus.lock.Unlock()
+
+	s, exists := m.bus.snapshots[name]
+	if !exists {
+		s = &SnapshotStore{}
+		m.bus.snapshots[name] = s
+	}
+
+	return s, nil
+}
+
+// Put stores a snapshot covering messages up to and including seq
+func (s *SnapshotStore) Put(ctx context.Context, seq uint64, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "failed to ReadAll")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.snap = &fabric.Snapshot{
+		Sequence: seq,
+		Time:     time.Now(),
+		Size:     uint64(len(data)),
+	}
+
+	s.data = data
+
+	return nil
+}
+
+// Latest returns information about the latest snapshot
+func (s *SnapshotStore) Latest(ctx context.Context) (*fabric.Snapshot, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.snap == nil {
+		return nil, fabric.ErrNoSnapshot
+	}
+
+	snap := *s.snap
+
+	return &snap, nil
+}
+
+// Open returns information about the latest snapshot and a reader for its data
+func (s *SnapshotStore) Open(ctx context.Context) (*fabric.Snapshot, io.ReadCloser, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.snap == nil {
+		return nil, nil, fabric.ErrNoSnapshot
+	}
+
+	snap := *s.snap
+
+	return &snap, io.NopCloser(bytes.NewReader(s.data)), nil
+}
@@ -0,0 +1,97 @@
+package fabricnats
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/cohix/libsdk/pkg/fabric"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// BroadcastConnection is a connection for fan-out messaging
+type BroadcastConnection struct {
+	log     slog.Logger
+	nc      *nats.Conn
+	codec   fabric.Codec
+	subject string
+	lock    sync.Mutex
+	subs    []*nats.Subscription
+}
+
+// Broadcaster returns a connection for fan-out messaging on subject. SERVICE.bcast.SUBJECT
+// is not attached to the stream, so broadcast messages are not persisted.
+func (n *Nats) Broadcaster(ctx context.Context, subject string) (fabric.BroadcastConnection, error) {
+	b := &BroadcastConnection{
+		log:     *slog.With("lib", "libsdk", "pkg", "fabricnats"),
+		nc:      n.nc,
+		codec:   n.codec,
+		subject: fmt.Sprintf("%s.bcast.%s", n.serviceName, subject),
+	}
+
+	return b, nil
+}
+
+// Broadcast publishes msg to every subscriber of the connection's subject
+func (b *BroadcastConnection) Broadcast(ctx context.Context, msg any) error {
+	pubMsg, err := encode(b.codec, b.subject, msg)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode")
+	}
+
+	if err := b.nc.PublishMsg(pubMsg); err != nil {
+		return errors.Wrapf(err, "failed to nc.PublishMsg to %s", b.subject)
+	}
+
+	return nil
+}
+
+// Subscribe subscribes to the connection's subject without a queue group, so that every subscriber receives each message
+func (b *BroadcastConnection) Subscribe(ctx context.Context, gen fabric.Generator, receiver fabric.Receiver) error {
+	sub, err := b.nc.Subscribe(b.subject, func(msg *nats.Msg) {
+		obj := gen()
+
+		if err := decode(msg.Header, msg.Data, obj); err != nil {
+			b.log.Error(errors.Wrap(err, "failed to decode").Error())
+			return
+		}
+
+		if err := receiver(obj); err != nil {
+			b.log.Error(errors.Wrap(err, "failed to receive broadcast message").Error())
+		}
+	})
+
+	if err != nil {
+		return errors.Wrapf(err, "failed to nc.Subscribe to %s", b.subject)
+	}
+
+	b.lock.Lock()
+	b.subs = append(b.subs, sub)
+	b.lock.Unlock()
+
+	context.AfterFunc(ctx, func() {
+		if err := sub.Unsubscribe(); err != nil && !errors.Is(err, nats.ErrBadSubscription) {
+			b.log.Error(errors.Wrap(err, "failed to sub.Unsubscribe").Error())
+		}
+	})
+
+	return nil
+}
+
+// Close unsubscribes all of the connection's receivers
+func (b *BroadcastConnection) Close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for _, sub := range b.subs {
+		if err := sub.Unsubscribe(); err != nil && !errors.Is(err, nats.ErrBadSubscription) {
+			return errors.Wrap(err, "failed to sub.Unsubscribe")
+		}
+	}
+
+	b.subs = nil
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package fabricnats
+
+import (
+	"testing"
+
+	"github.com/cohix/libsdk/pkg/fabric"
+	codeccbor "github.com/cohix/libsdk/pkg/fabric/codec-cbor"
+	codecmsgpack "github.com/cohix/libsdk/pkg/fabric/codec-msgpack"
+	"github.com/nats-io/nats.go"
+)
+
+type person struct {
+	Name string `json:"name"`
+}
+
+func TestEncodeRecordsCodec(t *testing.T) {
+	for _, codec := range []fabric.Codec{fabric.JSON, codeccbor.Codec, codecmsgpack.Codec} {
+		msg, err := encode(codec, "PEOPLE.store", person{Name: "rick"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if name := msg.Header.Get(fabric.CodecHeader); name != codec.Name() {
+			t.Fatalf("message encoded with %s has codec header %q", codec.Name(), name)
+		}
+
+		out := person{}
+
+		if err := decode(msg.Header, msg.Data, &out); err != nil {
+			t.Fatal(err)
+		}
+
+		if out.Name != "rick" {
+			t.Fatalf("decoded %+v from message encoded with %s, want rick", out, codec.Name())
+		}
+	}
+
+	// messages from before codecs were recorded are JSON
+	out := person{}
+
+	if err := decode(nats.Header{}, []byte(`{"name":"morty"}`), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Name != "morty" {
+		t.Fatalf("decoded %+v from message without a codec header, want morty", out)
+	}
+
+	unknown := nats.Header{}
+	unknown.Set(fabric.CodecHeader, "protobuf")
+
+	if err := decode(unknown, []byte{}, &out); err == nil {
+		t.Fatal("decoded message with an unregistered codec")
+	}
+}
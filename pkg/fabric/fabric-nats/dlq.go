@@ -0,0 +1,204 @@
+package fabricnats
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cohix/libsdk/pkg/fabric"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/pkg/errors"
+)
+
+// headers attached to dead lettered messages, alongside the original message's headers
+const subjectHeader = "Libsdk-Subject"
+const sequenceHeader = "Libsdk-Sequence"
+const errorHeader = "Libsdk-Error"
+const instanceHeader = "Libsdk-Instance"
+
+// dlqListWait is the maximum time DeadLetters waits for each batch of dead letters
+const dlqListWait = time.Second * 5
+
+// handleFailure naks msg so that it's redelivered after a backoff, or once it has been
+// delivered maxDeliveries times, moves it to the dead letter subject with recvErr attached.
+// It returns true if the message was dead lettered.
+func (b *ReplayConnection) handleFailure(ctx context.Context, msg jetstream.Msg, recvErr error) (bool, error) {
+	meta, err := msg.Metadata()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to msg.Metadata")
+	}
+
+	if meta.NumDelivered < maxDeliveries {
+		b.log.Warn("replayed message failed, redelivering", "seq", meta.Sequence.Stream, "attempt", meta.NumDelivered, "err", recvErr.Error())
+
+		if err := msg.NakWithDelay(redeliveryBackoff * time.Duration(meta.NumDelivered)); err != nil {
+			return false, errors.Wrap(err, "failed to msg.NakWithDelay")
+		}
+
+		return false, nil
+	}
+
+	b.log.Error("replayed message failed repeatedly, dead lettering", "seq", meta.Sequence.Stream, "err", recvErr.Error())
+
+	dead := nats.NewMsg(b.dlqSubject)
+	dead.Data = msg.Data()
+
+	for key, vals := range msg.Headers() {
+		dead.Header[key] = vals
+	}
+
+	dead.Header.Set(subjectHeader, msg.Subject())
+	dead.Header.Set(sequenceHeader, strconv.FormatUint(meta.Sequence.Stream, 10))
+	dead.Header.Set(errorHeader, recvErr.Error())
+	dead.Header.Set(instanceHeader, b.instance)
+
+	if _, err := b.publish(ctx, dead); err != nil {
+		// leave the message to be redelivered rather than lose it
+		if nakErr := msg.NakWithDelay(redeliveryBackoff * maxDeliveries); nakErr != nil {
+			return false, errors.Wrapf(nakErr, "failed to msg.NakWithDelay after failing to publish dead letter: %s", err.Error())
+		}
+
+		return false, errors.Wrap(err, "failed to publish dead letter")
+	}
+
+	if err := msg.Term(); err != nil {
+		return true, errors.Wrap(err, "failed to msg.Term")
+	}
+
+	return true, nil
+}
+
+// DeadLetters lists the messages from the connection's subject that this instance dead lettered
+func (b *ReplayConnection) DeadLetters(ctx context.Context) ([]fabric.DeadLetter, error) {
+	c, err := b.stream.CreateConsumer(ctx, jetstream.ConsumerConfig{
+		DeliverPolicy:     jetstream.DeliverAllPolicy,
+		AckPolicy:         jetstream.AckNonePolicy,
+		FilterSubject:     b.dlqSubject,
+		InactiveThreshold: time.Minute,
+	})
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to CreateConsumer")
+	}
+
+	info := c.CachedInfo()
+
+	defer func() {
+		if err := b.stream.DeleteConsumer(context.Background(), info.Name); err != nil {
+			b.log.Warn(errors.Wrap(err, "failed to DeleteConsumer").Error())
+		}
+	}()
+
+	letters := []fabric.DeadLetter{}
+	received := uint64(0)
+
+	for received < info.NumPending {
+		batch, err := c.Fetch(int(info.NumPending-received), jetstream.FetchMaxWait(dlqListWait))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to Fetch")
+		}
+
+		count := 0
+
+		for msg := range batch.Messages() {
+			count++
+
+			letter, err := deadLetter(msg)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to deadLetter")
+			}
+
+			if letter.Subject == b.subject && letter.Instance == b.instance {
+				letters = append(letters, *letter)
+			}
+		}
+
+		if err := batch.Error(); err != nil {
+			return nil, errors.Wrap(err, "failed to fetch batch")
+		}
+
+		if count == 0 {
+			break
+		}
+
+		received += uint64(count)
+	}
+
+	return letters, nil
+}
+
+// Redrive delivers the dead lettered message with the given ID to the connection's receiver,
+// and deletes it from the dead letter subject if the receiver succeeds
+func (b *ReplayConnection) Redrive(ctx context.Context, id uint64) error {
+	b.lock.Lock()
+	gen, recv := b.gen, b.recv
+	b.lock.Unlock()
+
+	if recv == nil {
+		return errors.New("cannot Redrive before Replay has started")
+	}
+
+	raw, err := b.stream.GetMsg(ctx, id)
+	if err != nil {
+		return errors.Wrapf(err, "failed to GetMsg %d", id)
+	}
+
+	if raw.Subject != b.dlqSubject || raw.Header.Get(subjectHeader) != b.subject {
+		return fmt.Errorf("message %d is not a dead letter for %s", id, b.subject)
+	}
+
+	if instance := raw.Header.Get(instanceHeader); instance != b.instance {
+		return fmt.Errorf("message %d was dead lettered by instance %q, not this instance %q", id, instance, b.instance)
+	}
+
+	seq, err := strconv.ParseUint(raw.Header.Get(sequenceHeader), 10, 64)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse %s header", sequenceHeader)
+	}
+
+	obj := gen()
+
+	if err := decode(raw.Header, raw.Data, obj); err != nil {
+		return errors.Wrap(err, "failed to decode")
+	}
+
+	if err := recv(seq, obj); err != nil {
+		return errors.Wrap(err, "failed to receive redriven message")
+	}
+
+	if err := b.stream.DeleteMsg(ctx, id); err != nil {
+		return errors.Wrapf(err, "failed to DeleteMsg %d", id)
+	}
+
+	return nil
+}
+
+// deadLetter converts a message from the dead letter subject into a fabric.DeadLetter
+func deadLetter(msg jetstream.Msg) (*fabric.DeadLetter, error) {
+	meta, err := msg.Metadata()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to msg.Metadata")
+	}
+
+	headers := msg.Headers()
+
+	seq, err := strconv.ParseUint(headers.Get(sequenceHeader), 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s header", sequenceHeader)
+	}
+
+	d := &fabric.DeadLetter{
+		ID:       meta.Sequence.Stream,
+		Subject:  headers.Get(subjectHeader),
+		Instance: headers.Get(instanceHeader),
+		Sequence: seq,
+		Error:    headers.Get(errorHeader),
+		Time:     meta.Timestamp,
+		Codec:    headers.Get(fabric.CodecHeader),
+		Data:     msg.Data(),
+	}
+
+	return d, nil
+}
@@ -0,0 +1,240 @@
+package fabricnats
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cohix/libsdk/pkg/fabric"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// fakeMsg is a delivered message that records how it was acknowledged
+type fakeMsg struct {
+	jetstream.Msg
+	msg    *nats.Msg
+	meta   jetstream.MsgMetadata
+	naks   []time.Duration
+	termed bool
+}
+
+func (f *fakeMsg) Metadata() (*jetstream.MsgMetadata, error) { return &f.meta, nil }
+func (f *fakeMsg) Data() []byte                              { return f.msg.Data }
+func (f *fakeMsg) Headers() nats.Header                      { return f.msg.Header }
+func (f *fakeMsg) Subject() string                           { return f.msg.Subject }
+
+func (f *fakeMsg) NakWithDelay(delay time.Duration) error {
+	f.naks = append(f.naks, delay)
+
+	return nil
+}
+
+func (f *fakeMsg) Term() error {
+	f.termed = true
+
+	return nil
+}
+
+// dlqStream holds dead letters by ID
+type dlqStream struct {
+	jetstream.Stream
+	msgs map[uint64]*jetstream.RawStreamMsg
+}
+
+func (d *dlqStream) GetMsg(ctx context.Context, seq uint64, opts ...jetstream.GetMsgOpt) (*jetstream.RawStreamMsg, error) {
+	msg, exists := d.msgs[seq]
+	if !exists {
+		return nil, jetstream.ErrMsgNotFound
+	}
+
+	return msg, nil
+}
+
+func (d *dlqStream) DeleteMsg(ctx context.Context, seq uint64) error {
+	delete(d.msgs, seq)
+
+	return nil
+}
+
+// failing returns a delivered message for PEOPLE.store at stream sequence 7
+func failing(t *testing.T, delivered uint64) *fakeMsg {
+	t.Helper()
+
+	msg, err := encode(fabric.JSON, "PEOPLE.store", person{Name: "rick"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta := jetstream.MsgMetadata{NumDelivered: delivered, Timestamp: time.Now()}
+	meta.Sequence.Stream = 7
+
+	return &fakeMsg{msg: msg, meta: meta}
+}
+
+func replayConnection(publish func(ctx context.Context, msg *nats.Msg, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error)) *ReplayConnection {
+	r := &ReplayConnection{
+		log:        *slog.Default(),
+		subject:    "PEOPLE.store",
+		dlqSubject: "PEOPLE.dlq",
+		instance:   "people-0",
+		codec:      fabric.JSON,
+		publish:    publish,
+	}
+
+	return r
+}
+
+func TestHandleFailure(t *testing.T) {
+	ctx := context.Background()
+	recvErr := errors.New("no such table: people")
+
+	published := []*nats.Msg{}
+
+	r := replayConnection(func(ctx context.Context, msg *nats.Msg, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+		published = append(published, msg)
+		return &jetstream.PubAck{}, nil
+	})
+
+	// failed messages are redelivered with an increasing backoff
+	for delivered := uint64(1); delivered < maxDeliveries; delivered++ {
+		msg := failing(t, delivered)
+
+		dead, err := r.handleFailure(ctx, msg, recvErr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if dead || msg.termed || !slices.Equal(msg.naks, []time.Duration{redeliveryBackoff * time.Duration(delivered)}) {
+			t.Fatalf("delivery %d was dead lettered or naked with %v, want redelivered after %s", delivered, msg.naks, redeliveryBackoff*time.Duration(delivered))
+		}
+	}
+
+	if len(published) != 0 {
+		t.Fatalf("%d messages dead lettered before the last delivery", len(published))
+	}
+
+	// and dead lettered on the last delivery
+	msg := failing(t, maxDeliveries)
+
+	dead, err := r.handleFailure(ctx, msg, recvErr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !dead || !msg.termed || len(msg.naks) != 0 || len(published) != 1 {
+		t.Fatal("last delivery wasn't dead lettered and terminated")
+	}
+
+	letter := published[0]
+
+	if letter.Subject != "PEOPLE.dlq" || string(letter.Data) != string(msg.Data()) {
+		t.Fatalf("dead letter published to %s with %s, want PEOPLE.dlq with the original data", letter.Subject, letter.Data)
+	}
+
+	headers := map[string]string{
+		subjectHeader:      "PEOPLE.store",
+		sequenceHeader:     "7",
+		errorHeader:        recvErr.Error(),
+		instanceHeader:     "people-0",
+		fabric.CodecHeader: fabric.JSON.Name(),
+	}
+
+	for key, val := range headers {
+		if got := letter.Header.Get(key); got != val {
+			t.Fatalf("dead letter header %s is %q, want %q", key, got, val)
+		}
+	}
+
+	// a dead letter that can't be published is left to be redelivered
+	r.publish = func(ctx context.Context, msg *nats.Msg, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+		return nil, nats.ErrConnectionClosed
+	}
+
+	msg = failing(t, maxDeliveries)
+
+	dead, err = r.handleFailure(ctx, msg, recvErr)
+	if err == nil || dead || msg.termed || !slices.Equal(msg.naks, []time.Duration{redeliveryBackoff * maxDeliveries}) {
+		t.Fatalf("unpublished dead letter returned %v and was naked with %v, want an error and redelivery", err, msg.naks)
+	}
+}
+
+func TestRedrive(t *testing.T) {
+	ctx := context.Background()
+	stream := &dlqStream{msgs: map[uint64]*jetstream.RawStreamMsg{}}
+
+	r := replayConnection(func(ctx context.Context, msg *nats.Msg, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+		stream.msgs[100] = &jetstream.RawStreamMsg{Subject: msg.Subject, Sequence: 100, Header: msg.Header, Data: msg.Data, Time: time.Now()}
+		return &jetstream.PubAck{Sequence: 100}, nil
+	})
+
+	r.stream = stream
+
+	if _, err := r.handleFailure(ctx, failing(t, maxDeliveries), errors.New("no such table: people")); err != nil {
+		t.Fatal(err)
+	}
+
+	// dead letters are listed with the details recorded in their headers
+	raw := stream.msgs[100]
+
+	listed := &fakeMsg{msg: &nats.Msg{Subject: raw.Subject, Header: raw.Header, Data: raw.Data}}
+	listed.meta.Sequence.Stream = raw.Sequence
+
+	letter, err := deadLetter(listed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if letter.ID != 100 || letter.Subject != "PEOPLE.store" || letter.Sequence != 7 || letter.Instance != "people-0" ||
+		letter.Error != "no such table: people" || letter.Codec != fabric.JSON.Name() {
+		t.Fatalf("dead letter is %+v, want the details of the failed message", letter)
+	}
+
+	if err := r.Redrive(ctx, 100); err == nil {
+		t.Fatal("Redrive succeeded before Replay started")
+	}
+
+	received := []uint64{}
+
+	r.gen = func() any { return &person{} }
+	r.recv = func(seq uint64, obj any) error {
+		if obj.(*person).Name != "rick" {
+			t.Fatalf("redriven message is %+v, want rick", obj)
+		}
+
+		received = append(received, seq)
+
+		return nil
+	}
+
+	// another instance can't redrive it
+	other := *raw
+	other.Header = nats.Header(http.Header(raw.Header).Clone())
+	other.Header.Set(instanceHeader, "people-1")
+	stream.msgs[101] = &other
+
+	if err := r.Redrive(ctx, 101); err == nil {
+		t.Fatal("Redrive succeeded for another instance's dead letter")
+	}
+
+	// the redriven message is received with its original sequence and removed
+	if err := r.Redrive(ctx, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(received, []uint64{7}) {
+		t.Fatalf("received sequences %v, want the original sequence 7", received)
+	}
+
+	if _, exists := stream.msgs[100]; exists {
+		t.Fatal("redriven dead letter wasn't deleted")
+	}
+
+	if err := r.Redrive(ctx, 100); err == nil {
+		t.Fatal("Redrive succeeded for a deleted dead letter")
+	}
+}
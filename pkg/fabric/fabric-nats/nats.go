@@ -2,14 +2,16 @@ package fabricnats
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log/slog"
+	"math/rand"
 	"os"
+	"strings"
 	"sync"
+	"time"
+	"unicode"
 
 	"github.com/cohix/libsdk/pkg/fabric"
-	"github.com/gofrs/uuid"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 	"github.com/pkg/errors"
@@ -17,58 +19,124 @@ import (
 
 // local is the default (running on the same system)
 const localNatsAddr = nats.DefaultURL
-const natsAddrEnvKey = "LIBSDK_FABRIC_NATS_ADDR"
+
+// msgTimeout is the maximum time SendAndRecv waits for a reply
+const msgTimeout = time.Second * 10
+
+// a replayed message that fails is redelivered up to maxDeliveries times, waiting
+// redeliveryBackoff multiplied by the number of attempts so far, before being dead lettered
+const maxDeliveries = 5
+const redeliveryBackoff = time.Second
+
+// consumers are removed by the server once they've been inactive for their threshold,
+// so that consumers abandoned by crashed processes don't accumulate. Durable consumers of
+// instances with a configured ID are kept for longer so that they can resume after a restart.
+const ephemeralInactiveThreshold = time.Minute * 5
+const durableInactiveThreshold = time.Hour * 72
 
 var _ fabric.Fabric = &Nats{}
+var _ fabric.Deduplicator = &ReplayConnection{}
 
 type Nats struct {
 	serviceName string
 	nc          *nats.Conn
 	js          jetstream.JetStream
 	s           jetstream.Stream
+	legacy      nats.JetStreamContext // the object store used for snapshots is only available from the legacy API
+	streamOpts  StreamOptions
+	codec       fabric.Codec
+	instance    string
+	resumable   bool // the instance ID was configured rather than generated, see Replayer
 }
 
-type MsgConnection struct{}
+// MsgConnection is a connection for request/reply messaging
+type MsgConnection struct {
+	log     slog.Logger
+	nc      *nats.Conn
+	codec   fabric.Codec
+	subject string
+	queue   string
+	lock    sync.Mutex
+	subs    []*nats.Subscription
+}
 
 // ReplayConnection is a connection for pub/sub/replay
 type ReplayConnection struct {
-	log      slog.Logger
-	subject  string
-	stream   jetstream.Stream
-	consumer jetstream.Consumer
-	info     *jetstream.ConsumerInfo
-	publish  func(ctx context.Context, subject string, payload []byte, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error)
+	log         slog.Logger
+	subject     string
+	dlqSubject  string
+	durableName string
+	durable     bool
+	resumable   bool
+	instance    string
+	stream      jetstream.Stream
+	consumer    jetstream.Consumer
+	codec       fabric.Codec
+	publish     func(ctx context.Context, msg *nats.Msg, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error)
+	lock        sync.Mutex
+	msgs        jetstream.MessagesContext
+	gen         fabric.Generator
+	recv        fabric.ReplayReceiver
 }
 
-// New creates a new NATS fabric
+// New creates a new NATS fabric configured by OptionsFromEnv
 func New(serviceName string) (*Nats, error) {
-	natsAddr := localNatsAddr
-	if envAddr, exists := os.LookupEnv(natsAddrEnvKey); exists {
-		natsAddr = envAddr
+	opts, err := OptionsFromEnv()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to OptionsFromEnv")
 	}
 
-	nc, err := nats.Connect(natsAddr)
+	return NewWithOptions(serviceName, opts)
+}
+
+// NewWithOptions creates a new NATS fabric configured by opts. Messages are encoded with
+// opts.Codec and decoded with the codec recorded in their header, so services using
+// different codecs can share subjects, such as during a migration between codecs.
+func NewWithOptions(serviceName string, opts Options) (*Nats, error) {
+	log := slog.With("lib", "libsdk", "pkg", "fabricnats")
+
+	natsOpts, err := opts.natsOptions(serviceName, log.Warn)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to natsOptions")
+	}
+
+	instance := opts.Instance
+	if instance == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to os.Hostname")
+		}
+
+		// several instances may run on one host, so each is distinguished by a random suffix
+		instance = fmt.Sprintf("%s-%08x", hostname, rand.Uint32())
+	}
+
+	nc, err := nats.Connect(strings.Join(opts.URLs, ","), natsOpts...)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to nats.Connect")
 	}
 
 	js, err := jetstream.New(nc)
 	if err != nil {
+		nc.Close()
 		return nil, errors.Wrap(err, "failed to jetstream.New")
 	}
 
-	s, err := js.CreateOrUpdateStream(context.Background(), jetstream.StreamConfig{
-		Name: serviceName,
-		// Subjects for SERVICE.store, and SERVICE.pub are attached to preserve their state. SERVICE.msg subjects are not persisted.
-		Subjects:    []string{fmt.Sprintf("%s.store", serviceName), fmt.Sprintf("%s.pub", serviceName)},
-		Storage:     jetstream.FileStorage,
-		Retention:   jetstream.LimitsPolicy,
-		MaxBytes:    32000000000, // 32GB
-		Compression: jetstream.S2Compression,
-	})
+	s, err := ensureStream(context.Background(), js, serviceName, opts.Stream)
+	if err != nil {
+		nc.Close()
+		return nil, errors.Wrap(err, "failed to ensureStream")
+	}
 
+	legacy, err := nc.JetStream()
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to CreateOrUpdateStream")
+		nc.Close()
+		return nil, errors.Wrap(err, "failed to nc.JetStream")
+	}
+
+	codec := opts.Codec
+	if codec == nil {
+		codec = fabric.JSON
 	}
 
 	n := &Nats{
@@ -76,6 +144,11 @@ func New(serviceName string) (*Nats, error) {
 		nc:          nc,
 		js:          js,
 		s:           s,
+		legacy:      legacy,
+		streamOpts:  opts.Stream,
+		codec:       codec,
+		instance:    instance,
+		resumable:   opts.Instance != "",
 	}
 
 	return n, nil
@@ -83,75 +156,152 @@ func New(serviceName string) (*Nats, error) {
 
 // Messenger returns a connection for message sending/receiving
 func (n *Nats) Messenger(service string) (fabric.MsgConnection, error) {
-	return &MsgConnection{}, nil
+	m := &MsgConnection{
+		log:   *slog.With("lib", "libsdk", "pkg", "fabricnats"),
+		nc:    n.nc,
+		codec: n.codec,
+		// SERVICE.msg is not attached to the stream, so messages are not persisted
+		subject: fmt.Sprintf("%s.msg", service),
+		queue:   service,
+	}
+
+	return m, nil
 }
 
-// Replayer returns a connection for Replayer publish/replay
-func (n *Nats) Replayer(subject string, beginning bool) (fabric.ReplayConnection, error) {
-	ctx := context.Background()
+// Replayer returns a connection for Replayer publish/replay. No consumer is created
+// until Replay is called, so connections that only publish don't create consumers.
+// Replaying from fabric.FromNow uses a durable consumer named for the subject and this
+// instance, resuming from where the instance left off. The default instance ID changes
+// each run, so LIBSDK_INSTANCE_ID must be set to a stable, unique ID to resume after a
+// restart. Without one, the durable consumer can't be resumed, so it's deleted on Close, or
+// by the server as an ephemeral consumer would be if the process exits without closing it.
+// Otherwise an ephemeral consumer is used, which is deleted on Close.
+func (n *Nats) Replayer(ctx context.Context, subject string) (fabric.ReplayConnection, error) {
+	fullSubject := fmt.Sprintf("%s.%s", n.serviceName, subject)
 
-	// all consumers are unique, even if there are multiple within
-	// a single server instance. For example, store and pub consumers
-	// have different behaviour and therefore have unique names
-	consumerUUID, err := uuid.NewV7()
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to uuid.NewV7")
+	b := &ReplayConnection{
+		log:         *slog.With("lib", "libsdk", "pkg", "fabricnats"),
+		subject:     fullSubject,
+		dlqSubject:  fmt.Sprintf("%s.dlq", n.serviceName),
+		stream:      n.s,
+		codec:       n.codec,
+		publish:     n.js.PublishMsg,
+		durableName: consumerName(n.serviceName, subject, n.instance),
+		resumable:   n.resumable,
+		instance:    n.instance,
 	}
 
-	consumerName := fmt.Sprintf("%s-consumer-%s", n.serviceName, consumerUUID.String())
-	fullSubject := fmt.Sprintf("%s.%s", n.serviceName, subject)
-
-	deliverPolicy := jetstream.DeliverAllPolicy
+	return b, nil
+}
 
-	if !beginning {
-		deliverPolicy = jetstream.DeliverNewPolicy
+// Close drains the NATS connection, allowing in-flight messages to be handled before closing
+func (n *Nats) Close() error {
+	if err := n.nc.Drain(); err != nil {
+		return errors.Wrap(err, "failed to nc.Drain")
 	}
 
-	c, err := n.s.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
-		Durable:       consumerName,
-		DeliverPolicy: deliverPolicy,
-		AckPolicy:     jetstream.AckExplicitPolicy,
-		FilterSubject: fullSubject,
-	})
+	return nil
+}
 
+// SendAndRecv sends a message to the connection's service and passes the reply to receiver
+func (m *MsgConnection) SendAndRecv(ctx context.Context, msg any, gen fabric.Generator, receiver fabric.Receiver) error {
+	req, err := encode(m.codec, m.subject, msg)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to CreateOrUpdateConsumer")
+		return errors.Wrap(err, "failed to encode")
 	}
 
-	// get consumer info to power the upTo channel in the replayer
-	info, err := c.Info(context.Background())
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, msgTimeout)
+		defer cancel()
+	}
+
+	reply, err := m.nc.RequestMsgWithContext(ctx, req)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to consumer.Info")
+		return errors.Wrapf(err, "failed to nc.Request to %s", m.subject)
 	}
 
-	b := &ReplayConnection{
-		log:      *slog.With("lib", "libsdk", "pkg", "fabricnats"),
-		subject:  fullSubject,
-		stream:   n.s,
-		consumer: c,
-		info:     info,
-		publish:  n.js.Publish,
+	obj := gen()
+
+	if err := decode(reply.Header, reply.Data, obj); err != nil {
+		return errors.Wrap(err, "failed to decode reply")
 	}
 
-	return b, nil
+	return receiver(obj)
 }
 
-func (m *MsgConnection) SendAndRecv(msg any, receiver fabric.Receiver) error {
+// RecvAndReply subscribes to the connection's service using a queue group so that each message is
+// handled by a single instance of the service, which can respond using the provided replier
+func (m *MsgConnection) RecvAndReply(ctx context.Context, gen fabric.Generator, handler fabric.Handler) error {
+	sub, err := m.nc.QueueSubscribe(m.subject, m.queue, func(msg *nats.Msg) {
+		obj := gen()
+
+		if err := decode(msg.Header, msg.Data, obj); err != nil {
+			m.log.Error(errors.Wrap(err, "failed to decode").Error())
+			return
+		}
+
+		replier := func(reply any) {
+			resp, err := encode(m.codec, msg.Reply, reply)
+			if err != nil {
+				m.log.Error(errors.Wrap(err, "failed to encode reply").Error())
+				return
+			}
+
+			if err := msg.RespondMsg(resp); err != nil {
+				m.log.Error(errors.Wrap(err, "failed to msg.Respond").Error())
+			}
+		}
+
+		handler(ctx, obj, replier)
+	})
+
+	if err != nil {
+		return errors.Wrapf(err, "failed to nc.QueueSubscribe to %s", m.subject)
+	}
+
+	m.lock.Lock()
+	m.subs = append(m.subs, sub)
+	m.lock.Unlock()
+
+	context.AfterFunc(ctx, func() {
+		if err := sub.Unsubscribe(); err != nil && !errors.Is(err, nats.ErrBadSubscription) {
+			m.log.Error(errors.Wrap(err, "failed to sub.Unsubscribe").Error())
+		}
+	})
+
 	return nil
 }
 
-func (m *MsgConnection) RecvAndReply(handler fabric.Handler) {
+// Close unsubscribes all of the connection's handlers
+func (m *MsgConnection) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, sub := range m.subs {
+		if err := sub.Unsubscribe(); err != nil && !errors.Is(err, nats.ErrBadSubscription) {
+			return errors.Wrap(err, "failed to sub.Unsubscribe")
+		}
+	}
 
+	m.subs = nil
+
+	return nil
 }
 
-// Publish publishes a message to a broadcast channel
-func (b *ReplayConnection) Publish(msg any) error {
-	body, err := json.Marshal(msg)
+// Publish publishes a message to a broadcast channel. Messages that are fabric.Identifiers are
+// deduplicated by JetStream within the stream's duplicate window.
+func (b *ReplayConnection) Publish(ctx context.Context, msg any) error {
+	pubMsg, err := encode(b.codec, b.subject, msg)
 	if err != nil {
-		return errors.Wrap(err, "failed to json.Marshal")
+		return errors.Wrap(err, "failed to encode")
 	}
 
-	_, err = b.publish(context.Background(), b.subject, body)
+	if identified, ok := msg.(fabric.Identifier); ok {
+		pubMsg.Header.Set(jetstream.MsgIDHeader, identified.MessageID())
+	}
+
+	_, err = b.publish(ctx, pubMsg)
 	if err != nil {
 		return errors.Wrap(err, "failed to publish")
 	}
@@ -159,7 +309,21 @@ func (b *ReplayConnection) Publish(msg any) error {
 	return nil
 }
 
-func (b *ReplayConnection) Replay(gen fabric.Generator, recv fabric.Receiver) (chan bool, error) {
+// DuplicateWindow returns the stream's duplicate window, which the server sets if it wasn't configured
+func (b *ReplayConnection) DuplicateWindow() time.Duration {
+	return b.stream.CachedInfo().Config.Duplicates
+}
+
+// Replay delivers messages from the given position to recv in order until ctx is cancelled or the connection is closed
+func (b *ReplayConnection) Replay(ctx context.Context, from fabric.Position, gen fabric.Generator, recv fabric.ReplayReceiver) (chan bool, error) {
+	c, err := b.createConsumer(ctx, from)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to createConsumer")
+	}
+
+	// consumer info from creation powers the upTo channel below
+	info := c.CachedInfo()
+
 	upToChan := make(chan bool, 1)
 	upToOnce := sync.Once{}
 	upToCounter := uint64(0)
@@ -172,45 +336,180 @@ func (b *ReplayConnection) Replay(gen fabric.Generator, recv fabric.Receiver) (c
 
 	// in the special case where this is the very first time a service is
 	// running and there are no messages in the stream at all, notify now
-	if b.info.NumPending == 0 {
+	if info.NumPending == 0 {
 		upToCompletion()
 	}
 
-	msgs, err := b.consumer.Messages()
+	msgs, err := c.Messages()
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to consumer.Messages")
 	}
 
+	b.lock.Lock()
+	b.msgs = msgs
+	b.gen = gen
+	b.recv = recv
+	b.lock.Unlock()
+
+	// stopping the iterator causes Next to return ErrMsgIteratorClosed, ending the loop below
+	stopAfter := context.AfterFunc(ctx, msgs.Stop)
+
 	go func() {
+		defer stopAfter()
+
 		for {
 			msg, err := msgs.Next()
 			if err != nil {
+				if errors.Is(err, jetstream.ErrMsgIteratorClosed) {
+					return
+				}
+
 				b.log.Error(errors.Wrap(err, "failed to msgs.Next").Error())
 				continue
 			}
 
+			// grab a typed object from the replay consumer
+			// via the generator into which we unmarshal the data
+			obj := gen()
+
+			recvErr := decode(msg.Headers(), msg.Data(), obj)
+			if recvErr == nil {
+				meta, err := msg.Metadata()
+				if err != nil {
+					recvErr = errors.Wrap(err, "failed to msg.Metadata")
+				} else {
+					recvErr = recv(meta.Sequence.Stream, obj)
+				}
+			}
+
+			if recvErr != nil {
+				deadLettered, err := b.handleFailure(ctx, msg, recvErr)
+				if err != nil {
+					b.log.Error(errors.Wrap(err, "failed to handleFailure").Error())
+				}
+
+				// the message will be redelivered, so it has not yet been replayed
+				if !deadLettered {
+					continue
+				}
+			} else if err := msg.Ack(); err != nil {
+				b.log.Error(errors.Wrap(err, "failed to msg.Ack").Error())
+			}
+
 			upToCounter++
-			msg.Ack()
 
 			// notify the caller when we've reached the point of the
 			// stream where we attached to it as a new consumer
 			// but only once as we'd be blocking message reading otherwise
-			if upToCounter >= b.info.NumPending {
+			if upToCounter >= info.NumPending {
 				upToCompletion()
 			}
+		}
+	}()
 
-			// grab a typed object from the replay consumer
-			// via the generator into which we unmarshal the data
-			obj := gen()
+	return upToChan, nil
+}
 
-			if err := json.Unmarshal(msg.Data(), obj); err != nil {
-				b.log.Error(errors.Wrap(err, "failed to json.Unmarshal").Error())
-				continue
-			}
+// Close stops replaying messages and deletes the connection's consumer, unless it's
+// a durable consumer that the instance can resume after a restart
+func (b *ReplayConnection) Close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
 
-			recv(obj)
+	if b.msgs != nil {
+		b.msgs.Stop()
+		b.msgs = nil
+	}
+
+	if b.consumer != nil && !(b.durable && b.resumable) {
+		name := b.consumer.CachedInfo().Name
+
+		if err := b.stream.DeleteConsumer(context.Background(), name); err != nil && !errors.Is(err, jetstream.ErrConsumerNotFound) {
+			return errors.Wrapf(err, "failed to DeleteConsumer %s", name)
 		}
-	}()
 
-	return upToChan, nil
+		b.consumer = nil
+	}
+
+	return nil
+}
+
+// createConsumer creates the connection's consumer, or for a durable consumer, reuses it if it exists
+func (b *ReplayConnection) createConsumer(ctx context.Context, from fabric.Position) (jetstream.Consumer, error) {
+	cfg := jetstream.ConsumerConfig{
+		DeliverPolicy:     jetstream.DeliverAllPolicy,
+		AckPolicy:         jetstream.AckExplicitPolicy,
+		FilterSubject:     b.subject,
+		InactiveThreshold: ephemeralInactiveThreshold,
+		// only one message is in flight at a time, so that a message
+		// being redelivered is never overtaken by the ones after it
+		MaxAckPending: 1,
+	}
+
+	switch {
+	case from.Now:
+		cfg.Durable = b.durableName
+		cfg.DeliverPolicy = jetstream.DeliverNewPolicy
+
+		if b.resumable {
+			cfg.InactiveThreshold = durableInactiveThreshold
+		}
+	case from.After > 0:
+		cfg.DeliverPolicy = jetstream.DeliverByStartSequencePolicy
+		cfg.OptStartSeq = from.After + 1
+	}
+
+	c, err := b.stream.CreateOrUpdateConsumer(ctx, cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to CreateOrUpdateConsumer")
+	}
+
+	b.lock.Lock()
+	b.consumer = c
+	b.durable = from.Now
+	b.lock.Unlock()
+
+	return c, nil
+}
+
+// consumerName returns a stable consumer name for an instance's consumer of a subject
+func consumerName(serviceName, subject, instance string) string {
+	name := fmt.Sprintf("%s-%s-%s", serviceName, subject, instance)
+
+	// consumer names cannot contain whitespace, '.', '*', '>', or path separators
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || strings.ContainsRune(".*>/\\", r) {
+			return '_'
+		}
+
+		return r
+	}, name)
+}
+
+// encode marshals obj with codec into a message for subject, recording the codec in its header
+func encode(codec fabric.Codec, subject string, obj any) (*nats.Msg, error) {
+	body, err := codec.Marshal(obj)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to %s Marshal", codec.Name())
+	}
+
+	msg := nats.NewMsg(subject)
+	msg.Header.Set(fabric.CodecHeader, codec.Name())
+	msg.Data = body
+
+	return msg, nil
+}
+
+// decode unmarshals data into obj using the codec recorded in header
+func decode(header nats.Header, data []byte, obj any) error {
+	codec, err := fabric.CodecByName(header.Get(fabric.CodecHeader))
+	if err != nil {
+		return errors.Wrap(err, "failed to CodecByName")
+	}
+
+	if err := codec.Unmarshal(data, obj); err != nil {
+		return errors.Wrapf(err, "failed to %s Unmarshal", codec.Name())
+	}
+
+	return nil
 }
@@ -0,0 +1,87 @@
+package fabricnats
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/cohix/libsdk/pkg/fabric"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func (f *fakeStream) CreateOrUpdateConsumer(ctx context.Context, cfg jetstream.ConsumerConfig) (jetstream.Consumer, error) {
+	f.consumers = append(f.consumers, cfg)
+
+	return &fakeConsumer{info: jetstream.ConsumerInfo{Name: cfg.Durable, Config: cfg}}, nil
+}
+
+func (f *fakeStream) DeleteConsumer(ctx context.Context, name string) error {
+	f.deleted = append(f.deleted, name)
+
+	return nil
+}
+
+// fakeConsumer holds a consumer's info
+type fakeConsumer struct {
+	jetstream.Consumer
+	info jetstream.ConsumerInfo
+}
+
+func (f *fakeConsumer) CachedInfo() *jetstream.ConsumerInfo {
+	return &f.info
+}
+
+func TestConsumerLifetime(t *testing.T) {
+	cases := []struct {
+		name      string
+		from      fabric.Position
+		resumable bool
+		threshold time.Duration
+		deleted   bool
+	}{
+		{"resumable durable", fabric.FromNow, true, durableInactiveThreshold, false},
+		{"generated instance durable", fabric.FromNow, false, ephemeralInactiveThreshold, true},
+		{"ephemeral", fabric.FromBeginning, true, ephemeralInactiveThreshold, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			stream := &fakeStream{}
+
+			r := &ReplayConnection{
+				log:         *slog.Default(),
+				subject:     "PEOPLE.store",
+				durableName: consumerName("PEOPLE", "PEOPLE.store", "people-0"),
+				resumable:   c.resumable,
+				stream:      stream,
+			}
+
+			if _, err := r.createConsumer(context.Background(), c.from); err != nil {
+				t.Fatal(err)
+			}
+
+			if threshold := stream.consumers[0].InactiveThreshold; threshold != c.threshold {
+				t.Fatalf("consumer inactive threshold is %s, want %s", threshold, c.threshold)
+			}
+
+			if err := r.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if deleted := len(stream.deleted) > 0; deleted != c.deleted {
+				t.Fatalf("consumer deleted on Close is %t, want %t", deleted, c.deleted)
+			}
+		})
+	}
+}
+
+func TestConsumerName(t *testing.T) {
+	if name := consumerName("PEOPLE", "PEOPLE.store", "host 1/a"); name != "PEOPLE-PEOPLE_store-host_1_a" {
+		t.Fatalf("consumer name is %s, want invalid characters replaced", name)
+	}
+
+	if consumerName("PEOPLE", "PEOPLE.store", "a") == consumerName("PEOPLE", "PEOPLE.store", "b") {
+		t.Fatal("instances share a consumer name")
+	}
+}
@@ -0,0 +1,212 @@
+package fabricnats
+
+import (
+	"crypto/tls"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cohix/libsdk/pkg/fabric"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// environment variables read by OptionsFromEnv
+const (
+	natsAddrEnvKey      = "LIBSDK_FABRIC_NATS_ADDR" // comma separated list of server URLs
+	credsEnvKey         = "LIBSDK_FABRIC_NATS_CREDS"
+	nkeyEnvKey          = "LIBSDK_FABRIC_NATS_NKEY"
+	jwtEnvKey           = "LIBSDK_FABRIC_NATS_JWT"
+	seedEnvKey          = "LIBSDK_FABRIC_NATS_SEED"
+	tokenEnvKey         = "LIBSDK_FABRIC_NATS_TOKEN"
+	userEnvKey          = "LIBSDK_FABRIC_NATS_USER"
+	passwordEnvKey      = "LIBSDK_FABRIC_NATS_PASSWORD"
+	tlsCertEnvKey       = "LIBSDK_FABRIC_NATS_TLS_CERT"
+	tlsKeyEnvKey        = "LIBSDK_FABRIC_NATS_TLS_KEY"
+	tlsCAEnvKey         = "LIBSDK_FABRIC_NATS_TLS_CA"
+	maxReconnectsEnvKey = "LIBSDK_FABRIC_NATS_MAX_RECONNECTS"
+	reconnectWaitEnvKey = "LIBSDK_FABRIC_NATS_RECONNECT_WAIT" // a time.Duration string, i.e. 2s
+	codecEnvKey         = "LIBSDK_FABRIC_CODEC"
+	instanceEnvKey      = "LIBSDK_INSTANCE_ID"
+)
+
+// Options configures the NATS fabric's connection
+type Options struct {
+	// URLs of the NATS servers to connect to. Providing several servers
+	// of a cluster allows connecting and reconnecting to any of them.
+	URLs []string
+
+	// TLS client certificate and key, and the CA used to verify the servers,
+	// all as file paths. TLSConfig takes precedence over the files when set.
+	TLSCertFile string
+	TLSKeyFile  string
+	TLSCAFile   string
+	TLSConfig   *tls.Config
+
+	// Authentication, only one method should be used. CredsFile is a chained JWT
+	// and nkey seed credentials file, NKeyFile is an nkey seed file, and UserJWT
+	// and NKeySeed provide a JWT and its nkey seed directly.
+	CredsFile string
+	NKeyFile  string
+	UserJWT   string
+	NKeySeed  string
+	Token     string
+	User      string
+	Password  string
+
+	// MaxReconnects is the number of reconnect attempts before giving up, -1 for unlimited.
+	// ReconnectWait is the time to wait between attempts to reconnect to the same server.
+	MaxReconnects int
+	ReconnectWait time.Duration
+
+	// Callbacks for connection state changes, called after they are logged. Optional.
+	OnDisconnect func(err error)
+	OnReconnect  func(url string)
+	OnClosed     func()
+
+	// Stream configures the service's JetStream stream.
+	Stream StreamOptions
+
+	// Codec used to encode messages, defaulting to JSON.
+	Codec fabric.Codec
+
+	// Instance uniquely identifies this instance of the service, defaulting to the hostname with a random suffix.
+	// It must be set to an ID that's stable across restarts for the instance's durable consumers to be resumed.
+	Instance string
+}
+
+// DefaultOptions returns Options for an unsecured local NATS server that reconnects indefinitely
+func DefaultOptions() Options {
+	o := Options{
+		URLs:          []string{localNatsAddr},
+		MaxReconnects: -1,
+		ReconnectWait: time.Second * 2,
+		Stream:        DefaultStreamOptions(),
+		Codec:         fabric.JSON,
+	}
+
+	return o
+}
+
+// OptionsFromEnv returns DefaultOptions overridden by any LIBSDK_FABRIC_NATS_* environment variables,
+// including those read by StreamOptionsFromEnv
+func OptionsFromEnv() (Options, error) {
+	o := DefaultOptions()
+
+	if addr, exists := os.LookupEnv(natsAddrEnvKey); exists {
+		o.URLs = strings.Split(addr, ",")
+	}
+
+	o.CredsFile = os.Getenv(credsEnvKey)
+	o.NKeyFile = os.Getenv(nkeyEnvKey)
+	o.UserJWT = os.Getenv(jwtEnvKey)
+	o.NKeySeed = os.Getenv(seedEnvKey)
+	o.Token = os.Getenv(tokenEnvKey)
+	o.User = os.Getenv(userEnvKey)
+	o.Password = os.Getenv(passwordEnvKey)
+	o.TLSCertFile = os.Getenv(tlsCertEnvKey)
+	o.TLSKeyFile = os.Getenv(tlsKeyEnvKey)
+	o.TLSCAFile = os.Getenv(tlsCAEnvKey)
+	o.Instance = os.Getenv(instanceEnvKey)
+
+	if max, exists := os.LookupEnv(maxReconnectsEnvKey); exists {
+		maxReconnects, err := strconv.Atoi(max)
+		if err != nil {
+			return o, errors.Wrapf(err, "failed to parse %s", maxReconnectsEnvKey)
+		}
+
+		o.MaxReconnects = maxReconnects
+	}
+
+	if wait, exists := os.LookupEnv(reconnectWaitEnvKey); exists {
+		reconnectWait, err := time.ParseDuration(wait)
+		if err != nil {
+			return o, errors.Wrapf(err, "failed to parse %s", reconnectWaitEnvKey)
+		}
+
+		o.ReconnectWait = reconnectWait
+	}
+
+	stream, err := StreamOptionsFromEnv()
+	if err != nil {
+		return o, errors.Wrap(err, "failed to StreamOptionsFromEnv")
+	}
+
+	o.Stream = stream
+
+	codec, err := fabric.CodecByName(os.Getenv(codecEnvKey))
+	if err != nil {
+		return o, errors.Wrap(err, "failed to CodecByName")
+	}
+
+	o.Codec = codec
+
+	return o, nil
+}
+
+// natsOptions converts the Options into options for nats.Connect
+func (o Options) natsOptions(serviceName string, log func(msg string, args ...any)) ([]nats.Option, error) {
+	opts := []nats.Option{
+		nats.Name(serviceName),
+		nats.MaxReconnects(o.MaxReconnects),
+		nats.ReconnectWait(o.ReconnectWait),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				log("disconnected from NATS", "err", err.Error())
+			} else {
+				log("disconnected from NATS")
+			}
+
+			if o.OnDisconnect != nil {
+				o.OnDisconnect(err)
+			}
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			log("reconnected to NATS", "url", nc.ConnectedUrlRedacted())
+
+			if o.OnReconnect != nil {
+				o.OnReconnect(nc.ConnectedUrlRedacted())
+			}
+		}),
+		nats.ClosedHandler(func(_ *nats.Conn) {
+			log("NATS connection closed")
+
+			if o.OnClosed != nil {
+				o.OnClosed()
+			}
+		}),
+	}
+
+	if o.TLSConfig != nil {
+		opts = append(opts, nats.Secure(o.TLSConfig))
+	} else {
+		if o.TLSCertFile != "" || o.TLSKeyFile != "" {
+			opts = append(opts, nats.ClientCert(o.TLSCertFile, o.TLSKeyFile))
+		}
+
+		if o.TLSCAFile != "" {
+			opts = append(opts, nats.RootCAs(o.TLSCAFile))
+		}
+	}
+
+	switch {
+	case o.CredsFile != "":
+		opts = append(opts, nats.UserCredentials(o.CredsFile))
+	case o.NKeyFile != "":
+		nkeyOpt, err := nats.NkeyOptionFromSeed(o.NKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to NkeyOptionFromSeed")
+		}
+
+		opts = append(opts, nkeyOpt)
+	case o.UserJWT != "":
+		opts = append(opts, nats.UserJWTAndSeed(o.UserJWT, o.NKeySeed))
+	case o.Token != "":
+		opts = append(opts, nats.Token(o.Token))
+	case o.User != "":
+		opts = append(opts, nats.UserInfo(o.User, o.Password))
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,145 @@
+package fabricnats
+
+import (
+	"crypto/tls"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	codeccbor "github.com/cohix/libsdk/pkg/fabric/codec-cbor"
+	"github.com/nats-io/nats.go"
+)
+
+func TestOptionsFromEnv(t *testing.T) {
+	t.Setenv(natsAddrEnvKey, "nats://a:4222,nats://b:4222")
+	t.Setenv(userEnvKey, "rick")
+	t.Setenv(passwordEnvKey, "pickle")
+	t.Setenv(maxReconnectsEnvKey, "10")
+	t.Setenv(reconnectWaitEnvKey, "500ms")
+	t.Setenv(codecEnvKey, "cbor")
+	t.Setenv(instanceEnvKey, "people-0")
+	t.Setenv(replicasEnvKey, "3")
+
+	o, err := OptionsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(o.URLs, []string{"nats://a:4222", "nats://b:4222"}) {
+		t.Fatalf("URLs are %v, want both servers", o.URLs)
+	}
+
+	if o.User != "rick" || o.Password != "pickle" || o.MaxReconnects != 10 || o.ReconnectWait != time.Millisecond*500 {
+		t.Fatalf("options are %+v, want those set in the environment", o)
+	}
+
+	if o.Codec != codeccbor.Codec || o.Instance != "people-0" || o.Stream.Replicas != 3 {
+		t.Fatalf("codec, instance and stream are %s, %s and %+v, want cbor, people-0 and 3 replicas", o.Codec.Name(), o.Instance, o.Stream)
+	}
+
+	invalid := map[string]string{
+		maxReconnectsEnvKey: "forever",
+		reconnectWaitEnvKey: "2",
+		codecEnvKey:         "protobuf",
+		replicasEnvKey:      "three",
+	}
+
+	for key, value := range invalid {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+
+			if _, err := OptionsFromEnv(); err == nil {
+				t.Fatalf("OptionsFromEnv succeeded with %s=%s", key, value)
+			}
+		})
+	}
+}
+
+// apply applies the NATS options for o to NATS' defaults
+func apply(t *testing.T, o Options) nats.Options {
+	t.Helper()
+
+	opts, err := o.natsOptions("PEOPLE", func(msg string, args ...any) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied := nats.GetDefaultOptions()
+
+	for _, opt := range opts {
+		if err := opt(&applied); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return applied
+}
+
+func TestNatsOptions(t *testing.T) {
+	disconnected := make(chan error, 1)
+	reconnected := make(chan string, 1)
+	closed := make(chan bool, 1)
+
+	o := DefaultOptions()
+	o.User = "rick"
+	o.Password = "pickle"
+	o.TLSConfig = &tls.Config{ServerName: "nats.example.com"}
+	o.OnDisconnect = func(err error) { disconnected <- err }
+	o.OnReconnect = func(url string) { reconnected <- url }
+	o.OnClosed = func() { closed <- true }
+
+	applied := apply(t, o)
+
+	if applied.Name != "PEOPLE" || applied.MaxReconnect != -1 || applied.ReconnectWait != time.Second*2 {
+		t.Fatalf("connection options are %+v, want the service name and to reconnect indefinitely", applied)
+	}
+
+	if applied.User != "rick" || applied.Password != "pickle" {
+		t.Fatalf("user and password are %q and %q, want rick and pickle", applied.User, applied.Password)
+	}
+
+	if !applied.Secure || applied.TLSConfig != o.TLSConfig {
+		t.Fatal("connection doesn't use the TLS config")
+	}
+
+	// callbacks are called after the connection's state is logged
+	applied.DisconnectedErrCB(nil, errors.New("connection reset"))
+	applied.ReconnectedCB(nil)
+	applied.ClosedCB(nil)
+
+	if err := <-disconnected; err == nil || err.Error() != "connection reset" {
+		t.Fatalf("OnDisconnect called with %v, want connection reset", err)
+	}
+
+	<-reconnected
+	<-closed
+
+	// only one authentication method is used, preferring a JWT to a token or user
+	o = DefaultOptions()
+	o.UserJWT = "eyJ0eXAiOiJKV1QifQ"
+	o.NKeySeed = "SUAMK2FG4MI6UE3ACF3FK3OIQBCEIEZV7NSWFFEW63UXMRLFM2XLAXK4GY"
+	o.Token = "secret"
+	o.User = "rick"
+
+	applied = apply(t, o)
+
+	if applied.UserJWT == nil || applied.Token != "" || applied.User != "" {
+		t.Fatal("connection doesn't authenticate with only the JWT")
+	}
+
+	o = DefaultOptions()
+	o.Token = "secret"
+
+	if applied := apply(t, o); applied.Token != "secret" {
+		t.Fatalf("token is %q, want secret", applied.Token)
+	}
+
+	// a missing nkey seed fails before connecting
+	o = DefaultOptions()
+	o.NKeyFile = "missing.nk"
+
+	if _, err := o.natsOptions("PEOPLE", func(msg string, args ...any) {}); err == nil {
+		t.Fatal("natsOptions succeeded with a missing nkey seed file")
+	}
+}
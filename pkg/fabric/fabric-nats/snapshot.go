@@ -0,0 +1,131 @@
+package fabricnats
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/cohix/libsdk/pkg/fabric"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+var _ fabric.SnapshotStore = &SnapshotStore{}
+
+// SnapshotStore stores snapshots in a JetStream object store bucket named SERVICE-snapshots,
+// with one object per subject that is replaced each time a new snapshot is stored
+type SnapshotStore struct {
+	obs  nats.ObjectStore
+	name string
+}
+
+// Snapshots returns the snapshot store for subject, creating the service's bucket if needed.
+// The bucket uses the same storage, replicas and placement as the service's stream.
+func (n *Nats) Snapshots(ctx context.Context, subject string) (fabric.SnapshotStore, error) {
+	bucket := fmt.Sprintf("%s-snapshots", n.serviceName)
+
+	obs, err := n.legacy.ObjectStore(bucket)
+	if err != nil {
+		if !errors.Is(err, nats.ErrStreamNotFound) {
+			return nil, errors.Wrapf(err, "failed to ObjectStore %s", bucket)
+		}
+
+		cfg := &nats.ObjectStoreConfig{
+			Bucket:   bucket,
+			Storage:  nats.StorageType(n.streamOpts.Storage),
+			Replicas: n.streamOpts.Replicas,
+		}
+
+		if n.streamOpts.Cluster != "" || len(n.streamOpts.Tags) > 0 {
+			cfg.Placement = &nats.Placement{
+				Cluster: n.streamOpts.Cluster,
+				Tags:    n.streamOpts.Tags,
+			}
+		}
+
+		obs, err = n.legacy.CreateObjectStore(cfg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to CreateObjectStore %s", bucket)
+		}
+	}
+
+	s := &SnapshotStore{
+		obs:  obs,
+		name: fmt.Sprintf("%s.%s", n.serviceName, subject),
+	}
+
+	return s, nil
+}
+
+// Put stores a snapshot covering messages up to and including seq
+func (s *SnapshotStore) Put(ctx context.Context, seq uint64, r io.Reader) error {
+	meta := &nats.ObjectMeta{
+		Name:    s.name,
+		Headers: nats.Header{},
+	}
+
+	meta.Headers.Set(sequenceHeader, strconv.FormatUint(seq, 10))
+
+	if _, err := s.obs.Put(meta, r, nats.Context(ctx)); err != nil {
+		return errors.Wrapf(err, "failed to obs.Put %s", s.name)
+	}
+
+	return nil
+}
+
+// Latest returns information about the latest snapshot
+func (s *SnapshotStore) Latest(ctx context.Context) (*fabric.Snapshot, error) {
+	info, err := s.obs.GetInfo(s.name, nats.Context(ctx))
+	if err != nil {
+		if errors.Is(err, nats.ErrObjectNotFound) {
+			return nil, fabric.ErrNoSnapshot
+		}
+
+		return nil, errors.Wrapf(err, "failed to obs.GetInfo %s", s.name)
+	}
+
+	return snapshot(info)
+}
+
+// Open returns information about the latest snapshot and a reader for its data
+func (s *SnapshotStore) Open(ctx context.Context) (*fabric.Snapshot, io.ReadCloser, error) {
+	result, err := s.obs.Get(s.name, nats.Context(ctx))
+	if err != nil {
+		if errors.Is(err, nats.ErrObjectNotFound) {
+			return nil, nil, fabric.ErrNoSnapshot
+		}
+
+		return nil, nil, errors.Wrapf(err, "failed to obs.Get %s", s.name)
+	}
+
+	info, err := result.Info()
+	if err != nil {
+		result.Close()
+		return nil, nil, errors.Wrap(err, "failed to result.Info")
+	}
+
+	snap, err := snapshot(info)
+	if err != nil {
+		result.Close()
+		return nil, nil, errors.Wrap(err, "failed to snapshot")
+	}
+
+	return snap, result, nil
+}
+
+// snapshot converts an object's info into a fabric.Snapshot
+func snapshot(info *nats.ObjectInfo) (*fabric.Snapshot, error) {
+	seq, err := strconv.ParseUint(info.Headers.Get(sequenceHeader), 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s header", sequenceHeader)
+	}
+
+	snap := &fabric.Snapshot{
+		Sequence: seq,
+		Time:     info.ModTime,
+		Size:     info.Size,
+	}
+
+	return snap, nil
+}
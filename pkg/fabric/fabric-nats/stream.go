@@ -0,0 +1,293 @@
+package fabricnats
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/pkg/errors"
+)
+
+// environment variables read by StreamOptionsFromEnv
+const (
+	replicasEnvKey   = "LIBSDK_FABRIC_NATS_REPLICAS"
+	clusterEnvKey    = "LIBSDK_FABRIC_NATS_CLUSTER"
+	tagsEnvKey       = "LIBSDK_FABRIC_NATS_TAGS" // comma separated list of placement tags
+	maxAgeEnvKey     = "LIBSDK_FABRIC_NATS_MAX_AGE"
+	maxMsgSizeEnvKey = "LIBSDK_FABRIC_NATS_MAX_MSG_SIZE"
+	maxBytesEnvKey   = "LIBSDK_FABRIC_NATS_MAX_BYTES"
+	duplicatesEnvKey = "LIBSDK_FABRIC_NATS_DUPLICATES"
+	storageEnvKey    = "LIBSDK_FABRIC_NATS_STORAGE" // file or memory
+)
+
+// StreamOptions configures the service's JetStream stream. Zero values for
+// MaxAge, MaxMsgSize and MaxBytes mean unlimited, and a zero Duplicates uses
+// the server's default duplicate window.
+type StreamOptions struct {
+	Replicas   int
+	Cluster    string   // place the stream in the named cluster, optional
+	Tags       []string // place the stream on servers with all of these tags, optional
+	MaxAge     time.Duration
+	MaxMsgSize int32
+	MaxBytes   int64
+	Duplicates time.Duration
+	Storage    jetstream.StorageType
+}
+
+// DefaultStreamOptions returns StreamOptions for a single replica file stream of up to 32GB
+func DefaultStreamOptions() StreamOptions {
+	s := StreamOptions{
+		Replicas: 1,
+		MaxBytes: 32000000000, // 32GB
+		Storage:  jetstream.FileStorage,
+	}
+
+	return s
+}
+
+// StreamOptionsFromEnv returns DefaultStreamOptions overridden by any stream related environment variables
+func StreamOptionsFromEnv() (StreamOptions, error) {
+	s := DefaultStreamOptions()
+
+	if replicas, exists := os.LookupEnv(replicasEnvKey); exists {
+		r, err := strconv.Atoi(replicas)
+		if err != nil {
+			return s, errors.Wrapf(err, "failed to parse %s", replicasEnvKey)
+		}
+
+		s.Replicas = r
+	}
+
+	s.Cluster = os.Getenv(clusterEnvKey)
+
+	if tags, exists := os.LookupEnv(tagsEnvKey); exists && tags != "" {
+		s.Tags = strings.Split(tags, ",")
+	}
+
+	if maxAge, exists := os.LookupEnv(maxAgeEnvKey); exists {
+		d, err := time.ParseDuration(maxAge)
+		if err != nil {
+			return s, errors.Wrapf(err, "failed to parse %s", maxAgeEnvKey)
+		}
+
+		s.MaxAge = d
+	}
+
+	if maxMsgSize, exists := os.LookupEnv(maxMsgSizeEnvKey); exists {
+		size, err := strconv.ParseInt(maxMsgSize, 10, 32)
+		if err != nil {
+			return s, errors.Wrapf(err, "failed to parse %s", maxMsgSizeEnvKey)
+		}
+
+		s.MaxMsgSize = int32(size)
+	}
+
+	if maxBytes, exists := os.LookupEnv(maxBytesEnvKey); exists {
+		size, err := strconv.ParseInt(maxBytes, 10, 64)
+		if err != nil {
+			return s, errors.Wrapf(err, "failed to parse %s", maxBytesEnvKey)
+		}
+
+		s.MaxBytes = size
+	}
+
+	if duplicates, exists := os.LookupEnv(duplicatesEnvKey); exists {
+		d, err := time.ParseDuration(duplicates)
+		if err != nil {
+			return s, errors.Wrapf(err, "failed to parse %s", duplicatesEnvKey)
+		}
+
+		s.Duplicates = d
+	}
+
+	if storage, exists := os.LookupEnv(storageEnvKey); exists {
+		switch strings.ToLower(storage) {
+		case "file":
+			s.Storage = jetstream.FileStorage
+		case "memory":
+			s.Storage = jetstream.MemoryStorage
+		default:
+			return s, fmt.Errorf("invalid %s %q, must be file or memory", storageEnvKey, storage)
+		}
+	}
+
+	return s, nil
+}
+
+// validate checks that the options can be used to create a stream
+func (s StreamOptions) validate() error {
+	if s.Replicas < 1 || s.Replicas > 5 {
+		return fmt.Errorf("stream replicas must be between 1 and 5, got %d", s.Replicas)
+	}
+
+	if s.MaxAge < 0 || s.MaxMsgSize < 0 || s.MaxBytes < 0 || s.Duplicates < 0 {
+		return errors.New("stream limits must not be negative")
+	}
+
+	if s.Duplicates > 0 && s.MaxAge > 0 && s.Duplicates > s.MaxAge {
+		return errors.New("stream duplicate window must not be longer than its max age")
+	}
+
+	return nil
+}
+
+// config returns the stream config for the service
+func (s StreamOptions) config(serviceName string) jetstream.StreamConfig {
+	c := jetstream.StreamConfig{
+		Name: serviceName,
+		// Subjects for SERVICE.store, SERVICE.pub, and SERVICE.dlq are attached to preserve their state. SERVICE.msg subjects are not persisted.
+		Subjects:   []string{fmt.Sprintf("%s.store", serviceName), fmt.Sprintf("%s.pub", serviceName), fmt.Sprintf("%s.dlq", serviceName)},
+		Storage:    s.Storage,
+		Retention:  jetstream.LimitsPolicy,
+		Replicas:   s.Replicas,
+		MaxAge:     s.MaxAge,
+		MaxMsgSize: s.MaxMsgSize,
+		MaxBytes:   s.MaxBytes,
+		Duplicates: s.Duplicates,
+	}
+
+	if s.Storage == jetstream.FileStorage {
+		c.Compression = jetstream.S2Compression
+	}
+
+	if s.MaxMsgSize == 0 {
+		c.MaxMsgSize = -1
+	}
+
+	if s.MaxBytes == 0 {
+		c.MaxBytes = -1
+	}
+
+	if s.Cluster != "" || len(s.Tags) > 0 {
+		c.Placement = &jetstream.Placement{
+			Cluster: s.Cluster,
+			Tags:    s.Tags,
+		}
+	}
+
+	return c
+}
+
+// ensureStream creates the service's stream, or if it already exists, checks that its
+// settings match the options. Rather than overwriting a stream with mismatched settings,
+// an error describing the differences is returned. Missing subjects are added.
+func ensureStream(ctx context.Context, js jetstream.JetStream, serviceName string, opts StreamOptions) (jetstream.Stream, error) {
+	if err := opts.validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid stream options")
+	}
+
+	want := opts.config(serviceName)
+
+	s, err := js.Stream(ctx, serviceName)
+	if err != nil {
+		if !errors.Is(err, jetstream.ErrStreamNotFound) {
+			return nil, errors.Wrap(err, "failed to js.Stream")
+		}
+
+		s, err = js.CreateStream(ctx, want)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to CreateStream")
+		}
+
+		return s, nil
+	}
+
+	have := s.CachedInfo().Config
+
+	if diffs := streamDiffs(have, want); len(diffs) > 0 {
+		return nil, fmt.Errorf("existing stream %s does not match the configured options: %s", serviceName, strings.Join(diffs, ", "))
+	}
+
+	missing := false
+
+	for _, subject := range want.Subjects {
+		if !slices.Contains(have.Subjects, subject) {
+			have.Subjects = append(have.Subjects, subject)
+			missing = true
+		}
+	}
+
+	if missing {
+		s, err = js.UpdateStream(ctx, have)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to UpdateStream")
+		}
+	}
+
+	return s, nil
+}
+
+// streamDiffs describes the configurable settings that differ between an existing stream and the desired config
+func streamDiffs(have, want jetstream.StreamConfig) []string {
+	diffs := []string{}
+
+	diff := func(name string, h, w any) {
+		diffs = append(diffs, fmt.Sprintf("%s is %v, configured %v", name, h, w))
+	}
+
+	if have.Storage != want.Storage {
+		diff("storage", have.Storage, want.Storage)
+	}
+
+	if have.Replicas != want.Replicas {
+		diff("replicas", have.Replicas, want.Replicas)
+	}
+
+	if have.MaxAge != want.MaxAge {
+		diff("max age", have.MaxAge, want.MaxAge)
+	}
+
+	if have.MaxMsgSize != want.MaxMsgSize {
+		diff("max msg size", have.MaxMsgSize, want.MaxMsgSize)
+	}
+
+	if have.MaxBytes != want.MaxBytes {
+		diff("max bytes", have.MaxBytes, want.MaxBytes)
+	}
+
+	// the server applies its own default when no duplicate window is configured
+	if want.Duplicates != 0 && have.Duplicates != want.Duplicates {
+		diff("duplicate window", have.Duplicates, want.Duplicates)
+	}
+
+	haveCluster, haveTags := "", []string{}
+	if have.Placement != nil {
+		haveCluster, haveTags = have.Placement.Cluster, have.Placement.Tags
+	}
+
+	wantCluster, wantTags := "", []string{}
+	if want.Placement != nil {
+		wantCluster, wantTags = want.Placement.Cluster, want.Placement.Tags
+	}
+
+	// the server fills in the cluster it placed the stream in when none was requested
+	if wantCluster != "" && haveCluster != wantCluster {
+		diff("placement cluster", haveCluster, wantCluster)
+	}
+
+	if !sameTags(haveTags, wantTags) {
+		diff("placement tags", haveTags, wantTags)
+	}
+
+	return diffs
+}
+
+// sameTags returns true if both sets of tags are the same, regardless of order
+func sameTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for _, t := range a {
+		if !slices.Contains(b, t) {
+			return false
+		}
+	}
+
+	return true
+}
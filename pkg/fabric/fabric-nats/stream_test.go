@@ -0,0 +1,190 @@
+package fabricnats
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// fakeJetStream holds a single stream, creating and updating it in memory
+type fakeJetStream struct {
+	jetstream.JetStream
+	stream  *fakeStream
+	updated bool
+}
+
+func (f *fakeJetStream) Stream(ctx context.Context, name string) (jetstream.Stream, error) {
+	if f.stream == nil {
+		return nil, jetstream.ErrStreamNotFound
+	}
+
+	return f.stream, nil
+}
+
+func (f *fakeJetStream) CreateStream(ctx context.Context, cfg jetstream.StreamConfig) (jetstream.Stream, error) {
+	f.stream = &fakeStream{info: jetstream.StreamInfo{Config: cfg}}
+
+	return f.stream, nil
+}
+
+func (f *fakeJetStream) UpdateStream(ctx context.Context, cfg jetstream.StreamConfig) (jetstream.Stream, error) {
+	f.stream.info.Config = cfg
+	f.updated = true
+
+	return f.stream, nil
+}
+
+// fakeStream holds a stream's info and records the consumers created on and deleted from it
+type fakeStream struct {
+	jetstream.Stream
+	info      jetstream.StreamInfo
+	consumers []jetstream.ConsumerConfig
+	deleted   []string
+}
+
+func (f *fakeStream) CachedInfo() *jetstream.StreamInfo {
+	return &f.info
+}
+
+func TestStreamOptionsFromEnv(t *testing.T) {
+	t.Setenv(replicasEnvKey, "3")
+	t.Setenv(clusterEnvKey, "east")
+	t.Setenv(tagsEnvKey, "ssd,fast")
+	t.Setenv(maxAgeEnvKey, "720h")
+	t.Setenv(maxMsgSizeEnvKey, "1048576")
+	t.Setenv(maxBytesEnvKey, "1000000")
+	t.Setenv(duplicatesEnvKey, "5m")
+	t.Setenv(storageEnvKey, "Memory")
+
+	s, err := StreamOptionsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := StreamOptions{
+		Replicas:   3,
+		Cluster:    "east",
+		Tags:       []string{"ssd", "fast"},
+		MaxAge:     time.Hour * 720,
+		MaxMsgSize: 1048576,
+		MaxBytes:   1000000,
+		Duplicates: time.Minute * 5,
+		Storage:    jetstream.MemoryStorage,
+	}
+
+	if s.Replicas != want.Replicas || s.Cluster != want.Cluster || !slices.Equal(s.Tags, want.Tags) || s.MaxAge != want.MaxAge ||
+		s.MaxMsgSize != want.MaxMsgSize || s.MaxBytes != want.MaxBytes || s.Duplicates != want.Duplicates || s.Storage != want.Storage {
+		t.Fatalf("stream options are %+v, want %+v", s, want)
+	}
+
+	invalid := map[string]string{
+		replicasEnvKey:   "three",
+		maxAgeEnvKey:     "30",
+		maxMsgSizeEnvKey: "4294967296",
+		maxBytesEnvKey:   "32GB",
+		duplicatesEnvKey: "forever",
+		storageEnvKey:    "disk",
+	}
+
+	for key, value := range invalid {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+
+			if _, err := StreamOptionsFromEnv(); err == nil {
+				t.Fatalf("StreamOptionsFromEnv succeeded with %s=%s", key, value)
+			}
+		})
+	}
+}
+
+func TestStreamOptionsValidate(t *testing.T) {
+	cases := []struct {
+		name  string
+		opts  func(s *StreamOptions)
+		valid bool
+	}{
+		{"defaults", func(s *StreamOptions) {}, true},
+		{"five replicas", func(s *StreamOptions) { s.Replicas = 5 }, true},
+		{"no replicas", func(s *StreamOptions) { s.Replicas = 0 }, false},
+		{"six replicas", func(s *StreamOptions) { s.Replicas = 6 }, false},
+		{"negative max age", func(s *StreamOptions) { s.MaxAge = -time.Hour }, false},
+		{"negative max bytes", func(s *StreamOptions) { s.MaxBytes = -1 }, false},
+		{"duplicates within max age", func(s *StreamOptions) { s.MaxAge, s.Duplicates = time.Hour, time.Minute }, true},
+		{"duplicates beyond max age", func(s *StreamOptions) { s.MaxAge, s.Duplicates = time.Minute, time.Hour }, false},
+		{"duplicates without max age", func(s *StreamOptions) { s.Duplicates = time.Hour }, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := DefaultStreamOptions()
+			c.opts(&s)
+
+			if err := s.validate(); (err == nil) != c.valid {
+				t.Fatalf("validate returned %v, want valid to be %t", err, c.valid)
+			}
+		})
+	}
+}
+
+func TestEnsureStream(t *testing.T) {
+	ctx := context.Background()
+	js := &fakeJetStream{}
+
+	opts := DefaultStreamOptions()
+	opts.Cluster = "east"
+
+	s, err := ensureStream(ctx, js, "PEOPLE", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := s.CachedInfo().Config
+
+	if cfg.Name != "PEOPLE" || !slices.Equal(cfg.Subjects, []string{"PEOPLE.store", "PEOPLE.pub", "PEOPLE.dlq"}) {
+		t.Fatalf("created stream %s with subjects %v, want PEOPLE with its store, pub and dlq subjects", cfg.Name, cfg.Subjects)
+	}
+
+	if cfg.MaxMsgSize != -1 || cfg.Compression != jetstream.S2Compression || cfg.Placement.Cluster != "east" {
+		t.Fatalf("created stream with config %+v, want unlimited message size, compression and placement", cfg)
+	}
+
+	// a stream created before dead letters existed gets its dlq subject, and
+	// the duplicate window the server filled in isn't a mismatch
+	js.stream.info.Config.Subjects = []string{"PEOPLE.store", "PEOPLE.pub"}
+	js.stream.info.Config.Duplicates = time.Minute * 2
+
+	if _, err := ensureStream(ctx, js, "PEOPLE", opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if !js.updated || !slices.Contains(js.stream.info.Config.Subjects, "PEOPLE.dlq") {
+		t.Fatalf("stream subjects are %v, want the dlq subject added", js.stream.info.Config.Subjects)
+	}
+
+	// the cluster the server placed the stream in isn't a mismatch when none was requested
+	if _, err := ensureStream(ctx, js, "PEOPLE", DefaultStreamOptions()); err != nil {
+		t.Fatal(err)
+	}
+
+	// mismatched settings aren't overwritten
+	js.updated = false
+	opts.Replicas = 3
+
+	if _, err := ensureStream(ctx, js, "PEOPLE", opts); err == nil {
+		t.Fatal("ensureStream succeeded with mismatched replicas")
+	}
+
+	if js.updated || js.stream.info.Config.Replicas != 1 {
+		t.Fatal("ensureStream changed the existing stream's replicas")
+	}
+
+	// invalid options fail before looking up the stream
+	opts.Replicas = 0
+
+	if _, err := ensureStream(ctx, &fakeJetStream{}, "PEOPLE", opts); err == nil {
+		t.Fatal("ensureStream succeeded with invalid options")
+	}
+}
@@ -1,9 +1,17 @@
 package fabric
 
-type Replier func(msg any)                  // function for sending message replies
-type Generator func() any                   // function to generate objects for message unmarshalling
-type Receiver func(msg any)                 // function for receiving messages
-type Handler func(msg any, replier Replier) // function for receiving messages and sending replies
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type Replier func(msg any)                                       // function for sending message replies
+type Generator func() any                                        // function to generate objects for message unmarshalling
+type Receiver func(msg any) error                                // function for receiving messages, returning an error if the message could not be handled
+type Handler func(ctx context.Context, msg any, replier Replier) // function for receiving messages and sending replies
+type ReplayReceiver func(seq uint64, msg any) error              // function for receiving replayed messages along with their sequence in the fabric
 
 type Fabric interface {
 	// Messenger is for async request/reply messaging with other services over the fabric.
@@ -11,17 +19,123 @@ type Fabric interface {
 	Messenger(service string) (MsgConnection, error)
 
 	// Create a 'replayer', i.e. a pub/sub connection with ordered messages that durably persist in the fabric.
-	// If 'beginning' is true, messages will be replayed from the "beginning of time".
-	// If false, messages will only be played from the current time. If only publishing, pass false.
-	Replayer(subject string, beginning bool) (ReplayConnection, error)
+	// The position from which messages are replayed is chosen when calling Replay.
+	Replayer(ctx context.Context, subject string) (ReplayConnection, error)
+
+	// Broadcaster returns a connection for sending messages to every instance of the service subscribed to subject.
+	// Broadcast messages are not persisted, and delivery is best-effort.
+	Broadcaster(ctx context.Context, subject string) (BroadcastConnection, error)
+
+	// Snapshots returns the store of point-in-time snapshots of the state built by replaying subject.
+	Snapshots(ctx context.Context, subject string) (SnapshotStore, error)
+
+	// Close closes the fabric and its underlying connections.
+	Close() error
 }
 
+// MsgConnection is a request/reply connection to a service. Messages are not persisted.
 type MsgConnection interface {
-	SendAndRecv(msg any, receiver Receiver) error
-	RecvAndReply(handler Handler)
+	// SendAndRecv sends msg to the connection's service and waits for a reply,
+	// which is unmarshalled into an object from gen and passed to receiver.
+	// If ctx has no deadline, a default timeout is applied. The receiver's error is returned.
+	SendAndRecv(ctx context.Context, msg any, gen Generator, receiver Receiver) error
+
+	// RecvAndReply handles messages sent to the connection's service. Messages are
+	// unmarshalled into objects from gen, and load balanced amongst all instances of the service.
+	// Handling stops when ctx is cancelled or the connection is closed.
+	RecvAndReply(ctx context.Context, gen Generator, handler Handler) error
+
+	// Close stops handling messages for the connection.
+	Close() error
+}
+
+// BroadcastConnection is a fan-out connection to every instance of a service. Messages are not persisted.
+type BroadcastConnection interface {
+	// Broadcast sends msg to every subscriber of the connection's subject.
+	Broadcast(ctx context.Context, msg any) error
+
+	// Subscribe passes each message broadcast to the connection's subject, unmarshalled into an
+	// object from gen, to receiver until ctx is cancelled or the connection is closed.
+	Subscribe(ctx context.Context, gen Generator, receiver Receiver) error
+
+	// Close stops receiving messages for the connection.
+	Close() error
 }
 
 type ReplayConnection interface {
-	Publish(msg any) error
-	Replay(gen Generator, receiver Receiver) (chan bool, error)
+	Publish(ctx context.Context, msg any) error
+
+	// Replay delivers messages from the given position to receiver until ctx is cancelled or the
+	// connection is closed. A message is acknowledged only once receiver returns without error. Failed
+	// messages are redelivered with a backoff, in order, and are moved to the service's dead letter subject
+	// (SERVICE.dlq) after repeated failures so that replay can continue.
+	Replay(ctx context.Context, from Position, gen Generator, receiver ReplayReceiver) (chan bool, error)
+
+	// DeadLetters lists the messages from the connection's subject that this instance dead lettered.
+	// Other instances may have received the same messages successfully, so only their own are listed.
+	DeadLetters(ctx context.Context) ([]DeadLetter, error)
+
+	// Redrive delivers the dead lettered message with the given ID to the receiver passed to Replay,
+	// and removes it from the dead letter subject if the receiver succeeds. Only messages this
+	// instance dead lettered can be redriven, as other instances have not necessarily failed them.
+	// The receiver is passed the message's original sequence, which is before those it's already received.
+	Redrive(ctx context.Context, id uint64) error
+
+	// Close stops replaying and releases the connection's resources.
+	Close() error
+}
+
+// Deduplicator is implemented by replay connections that discard messages published with the ID of a recent message
+type Deduplicator interface {
+	// DuplicateWindow returns how long after a message is published that others with its ID are discarded
+	DuplicateWindow() time.Duration
+}
+
+// Identifier is implemented by messages that carry a unique ID. Where supported, the fabric
+// discards a message published with the same ID as a recent message, such as when retrying.
+type Identifier interface {
+	MessageID() string
+}
+
+// Position is the point in a subject from which Replay starts delivering messages
+type Position struct {
+	Now   bool   // only deliver messages published from now on, resuming where the instance left off where supported
+	After uint64 // deliver messages with a sequence greater than After
+}
+
+// FromBeginning replays every message, from the "beginning of time"
+var FromBeginning = Position{}
+
+// FromNow replays only messages published after Replay is called
+var FromNow = Position{Now: true}
+
+// AfterSequence replays messages published after the message with sequence seq
+func AfterSequence(seq uint64) Position {
+	return Position{After: seq}
+}
+
+// DeadLetter is a message that could not be received after repeated delivery attempts
+type DeadLetter struct {
+	ID       uint64    // identifies the dead letter for Redrive
+	Subject  string    // the subject the message was originally published to
+	Instance string    // the instance that failed to receive the message
+	Sequence uint64    // the message's original position in the fabric
+	Error    string    // the error returned by the final delivery attempt
+	Time     time.Time // when the message was dead lettered
+	Codec    string    // the name of the codec that encoded Data
+	Data     []byte
+}
+
+// Decode unmarshals the dead lettered message into obj using the codec that encoded it
+func (d *DeadLetter) Decode(obj any) error {
+	codec, err := CodecByName(d.Codec)
+	if err != nil {
+		return errors.Wrap(err, "failed to CodecByName")
+	}
+
+	if err := codec.Unmarshal(d.Data, obj); err != nil {
+		return errors.Wrapf(err, "failed to %s Unmarshal", codec.Name())
+	}
+
+	return nil
 }
@@ -0,0 +1,34 @@
+package fabric
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNoSnapshot is returned when a SnapshotStore does not yet hold a snapshot
+var ErrNoSnapshot = errors.New("no snapshot exists")
+
+// SnapshotStore holds the latest point-in-time snapshot of the state built by replaying a subject,
+// allowing new instances to restore the snapshot and replay only the messages published after it.
+type SnapshotStore interface {
+	// Put stores the data read from r as a snapshot covering messages up to and including
+	// the given sequence, replacing the previous snapshot.
+	Put(ctx context.Context, seq uint64, r io.Reader) error
+
+	// Latest returns information about the latest snapshot, or ErrNoSnapshot.
+	Latest(ctx context.Context) (*Snapshot, error)
+
+	// Open returns information about the latest snapshot and a reader for its data, or ErrNoSnapshot.
+	// The reader must be closed by the caller.
+	Open(ctx context.Context) (*Snapshot, io.ReadCloser, error)
+}
+
+// Snapshot describes a snapshot held by a SnapshotStore
+type Snapshot struct {
+	Sequence uint64    // the sequence of the last message included in the snapshot
+	Time     time.Time // when the snapshot was stored
+	Size     uint64    // the size of the snapshot's data in bytes
+}
@@ -11,7 +11,7 @@ type AppHandlerFunc func(store *store.Store) http.Handler
 
 // App provides an application's logic to a service.
 type App interface {
-	Migrations() []string
+	Migrations() []store.Migration
 	Transactions() map[store.TxName]store.TxHandler
 	Public(store *store.Store) http.Handler
 	Private(store *store.Store) http.Handler
@@ -20,13 +20,16 @@ type App interface {
 
 // simpleApp is the minimum required
 type simpleApp struct {
-	migrations    []string
-	transactions  map[string]store.TxHandler
-	publicHandler AppHandlerFunc
+	migrations     []store.Migration
+	transactions   map[store.TxName]store.TxHandler
+	publicHandler  AppHandlerFunc
+	privateHandler AppHandlerFunc
 }
 
+var _ App = &simpleApp{}
+
 // SimpleApp returns a minimum viable App for use with Serve()
-func SimpleApp(migrations []string, transactions map[string]store.TxHandler, handler AppHandlerFunc) *simpleApp {
+func SimpleApp(migrations []store.Migration, transactions map[store.TxName]store.TxHandler, handler AppHandlerFunc) *simpleApp {
 	s := &simpleApp{
 		migrations:    migrations,
 		transactions:  transactions,
@@ -36,13 +39,20 @@ func SimpleApp(migrations []string, transactions map[string]store.TxHandler, han
 	return s
 }
 
+// WithPrivate sets the handler for private inter-service requests.
+func (s *simpleApp) WithPrivate(handler AppHandlerFunc) *simpleApp {
+	s.privateHandler = handler
+
+	return s
+}
+
 // Migrations returns the app's DB migrations.
-func (s *simpleApp) Migrations() []string {
+func (s *simpleApp) Migrations() []store.Migration {
 	return s.migrations
 }
 
 // Transactions returns the transactions available to the app.
-func (s *simpleApp) Transactions() map[string]store.TxHandler {
+func (s *simpleApp) Transactions() map[store.TxName]store.TxHandler {
 	return s.transactions
 }
 
@@ -53,7 +63,11 @@ func (s *simpleApp) Public(store *store.Store) http.Handler {
 
 // Private returns the HTTP router for private inter-service requests.
 func (s *simpleApp) Private(store *store.Store) http.Handler {
-	return http.NewServeMux()
+	if s.privateHandler == nil {
+		return http.NewServeMux()
+	}
+
+	return s.privateHandler(store)
 }
 
 // Log returns a logger configured to the preferences of the app.
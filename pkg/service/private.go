@@ -0,0 +1,213 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/cohix/libsdk/pkg/fabric"
+	"github.com/pkg/errors"
+)
+
+// PrivateRequest is an HTTP request sent to a service's private handler over the fabric
+type PrivateRequest struct {
+	Method string      `json:"method"`
+	URI    string      `json:"uri"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// PrivateResponse is the response from a service's private handler sent back over the fabric
+type PrivateResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// Transport is an http.RoundTripper that sends requests to the private handlers of other
+// services over the fabric. The URL's host is used as the service name, so a request for
+// http://ROLES/path is handled by the private handler of the ROLES service.
+type Transport struct {
+	fabric fabric.Fabric
+}
+
+var _ http.RoundTripper = &Transport{}
+
+// NewTransport creates a Transport that uses the provided fabric.
+func NewTransport(fabric fabric.Fabric) *Transport {
+	t := &Transport{
+		fabric: fabric,
+	}
+
+	return t
+}
+
+// RoundTrip sends the request to the private handler of the service named by the URL's host.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to ReadAll request body")
+		}
+
+		body = b
+	}
+
+	msgr, err := t.fabric.Messenger(req.URL.Host)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fabric.Messenger")
+	}
+
+	privReq := &PrivateRequest{
+		Method: req.Method,
+		URI:    req.URL.RequestURI(),
+		Header: req.Header,
+		Body:   body,
+	}
+
+	var privResp *PrivateResponse
+
+	gen := func() any {
+		return &PrivateResponse{}
+	}
+
+	receiver := func(msg any) error {
+		privResp = msg.(*PrivateResponse)
+		return nil
+	}
+
+	if err := msgr.SendAndRecv(req.Context(), privReq, gen, receiver); err != nil {
+		return nil, errors.Wrap(err, "failed to SendAndRecv")
+	}
+
+	resp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", privResp.Status, http.StatusText(privResp.Status)),
+		StatusCode:    privResp.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        privResp.Header,
+		Body:          io.NopCloser(bytes.NewReader(privResp.Body)),
+		ContentLength: int64(len(privResp.Body)),
+		Request:       req,
+	}
+
+	if resp.Header == nil {
+		resp.Header = http.Header{}
+	}
+
+	return resp, nil
+}
+
+// maxPrivateRequests is the number of private requests an instance handles concurrently,
+// after which the fabric's delivery of further requests waits for one to finish
+const maxPrivateRequests = 64
+
+// servePrivate serves the handler over the fabric, converting each message into an *http.Request
+// and sending the captured response back as the reply. Each request is handled on its own goroutine.
+func servePrivate(name string, msgr fabric.MsgConnection, handler http.Handler, log *slog.Logger) error {
+	gen := func() any {
+		return &PrivateRequest{}
+	}
+
+	inflight := make(chan struct{}, maxPrivateRequests)
+
+	msgHandler := func(ctx context.Context, msg any, replier fabric.Replier) {
+		inflight <- struct{}{}
+
+		go func() {
+			defer func() { <-inflight }()
+
+			replier(handlePrivate(ctx, name, handler, msg.(*PrivateRequest), log))
+		}()
+	}
+
+	if err := msgr.RecvAndReply(context.Background(), gen, msgHandler); err != nil {
+		return errors.Wrap(err, "failed to RecvAndReply")
+	}
+
+	return nil
+}
+
+// handlePrivate calls the handler with the request and returns its response. A handler
+// that panics is recovered, as net/http would, and results in an internal server error.
+func handlePrivate(ctx context.Context, name string, handler http.Handler, privReq *PrivateRequest, log *slog.Logger) (resp *PrivateResponse) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("private handler panicked", "uri", privReq.URI, "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+			resp = &PrivateResponse{Status: http.StatusInternalServerError}
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, privReq.Method, privReq.URI, bytes.NewReader(privReq.Body))
+	if err != nil {
+		log.Error(errors.Wrap(err, "failed to http.NewRequest").Error())
+		return &PrivateResponse{Status: http.StatusBadRequest}
+	}
+
+	if privReq.Header != nil {
+		req.Header = privReq.Header
+	}
+
+	req.Host = name
+	req.RequestURI = privReq.URI
+
+	w := &privateResponseWriter{
+		header: http.Header{},
+	}
+
+	handler.ServeHTTP(w, req)
+
+	return w.response()
+}
+
+// privateResponseWriter captures a private handler's response so it can be sent over the fabric
+type privateResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+// Header returns the response headers
+func (p *privateResponseWriter) Header() http.Header {
+	return p.header
+}
+
+// Write writes to the response body
+func (p *privateResponseWriter) Write(b []byte) (int, error) {
+	if p.status == 0 {
+		p.status = http.StatusOK
+	}
+
+	return p.body.Write(b)
+}
+
+// WriteHeader sets the response status, only the first call has any effect
+func (p *privateResponseWriter) WriteHeader(status int) {
+	if p.status == 0 {
+		p.status = status
+	}
+}
+
+func (p *privateResponseWriter) response() *PrivateResponse {
+	status := p.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	r := &PrivateResponse{
+		Status: status,
+		Header: p.header,
+		Body:   p.body.Bytes(),
+	}
+
+	return r
+}
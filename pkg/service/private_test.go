@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cohix/libsdk/pkg/fabric"
+	fabricmem "github.com/cohix/libsdk/pkg/fabric/fabric-mem"
+)
+
+func TestPrivateRoundTrip(t *testing.T) {
+	f := fabricmem.NewWithBus("PEOPLE", fabricmem.NewBus())
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		w.Header().Set("X-Caller", r.Header.Get("X-Caller"))
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "%s %s %s %s", r.Method, r.Host, r.RequestURI, body)
+	})
+
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("bug")
+	})
+
+	msgr, err := f.Messenger("ROLES")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := servePrivate("ROLES", msgr, mux, slog.Default()); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: NewTransport(f)}
+
+	req, err := http.NewRequest(http.MethodPost, "http://ROLES/echo?id=1", strings.NewReader("rick"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("X-Caller", "PEOPLE")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("X-Caller") != "PEOPLE" {
+		t.Fatalf("received %d with headers %v, want 201 with X-Caller PEOPLE", resp.StatusCode, resp.Header)
+	}
+
+	if string(body) != "POST ROLES /echo?id=1 rick" {
+		t.Fatalf("received body %q, want %q", body, "POST ROLES /echo?id=1 rick")
+	}
+
+	statuses := map[string]int{
+		"/missing": http.StatusNotFound,
+		"/panic":   http.StatusInternalServerError,
+	}
+
+	for path, want := range statuses {
+		resp, err := client.Get("http://ROLES" + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != want {
+			t.Fatalf("received %d for %s, want %d", resp.StatusCode, path, want)
+		}
+	}
+
+	if _, err := client.Get("http://NOBODY/echo"); err == nil {
+		t.Fatal("request to a service with no private handler succeeded")
+	}
+}
+
+// serialMessenger delivers messages to its handler one at a time on the caller's goroutine, as NATS does
+type serialMessenger struct {
+	fabric.MsgConnection
+	handler fabric.Handler
+}
+
+// RecvAndReply records the handler
+func (s *serialMessenger) RecvAndReply(ctx context.Context, gen fabric.Generator, handler fabric.Handler) error {
+	s.handler = handler
+	return nil
+}
+
+func TestPrivateRequestsConcurrent(t *testing.T) {
+	release := make(chan bool)
+	defer close(release)
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+
+	mux.HandleFunc("/fast", func(w http.ResponseWriter, r *http.Request) {})
+
+	msgr := &serialMessenger{}
+
+	if err := servePrivate("ROLES", msgr, mux, slog.Default()); err != nil {
+		t.Fatal(err)
+	}
+
+	replies := make(chan string, 2)
+
+	for _, uri := range []string{"/slow", "/fast"} {
+		msgr.handler(context.Background(), &PrivateRequest{Method: http.MethodGet, URI: uri}, func(msg any) {
+			replies <- uri
+		})
+	}
+
+	// a slow handler doesn't hold up the requests delivered after it
+	select {
+	case uri := <-replies:
+		if uri != "/fast" {
+			t.Fatalf("received reply to %s first, want /fast", uri)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for reply to /fast")
+	}
+}
@@ -1,11 +1,18 @@
 package service
 
 import (
+	"context"
 	"net/http"
 	"os"
 
 	"github.com/cohix/libsdk/pkg/fabric"
 	fabricnats "github.com/cohix/libsdk/pkg/fabric/fabric-nats"
+
+	// codecs register themselves so they can be selected with LIBSDK_FABRIC_CODEC,
+	// and so that messages encoded by any of them can be read
+	_ "github.com/cohix/libsdk/pkg/fabric/codec-cbor"
+	_ "github.com/cohix/libsdk/pkg/fabric/codec-msgpack"
+
 	"github.com/cohix/libsdk/pkg/store"
 	driversqlite "github.com/cohix/libsdk/pkg/store/driver-sqlite"
 	"github.com/pkg/errors"
@@ -20,6 +27,9 @@ type Service struct {
 
 	fabric fabric.Fabric
 	store  *store.Store
+
+	server *http.Server
+	msgr   fabric.MsgConnection
 }
 
 // New creates a Service with a SQLite store and NATS fabric.
@@ -29,17 +39,47 @@ func New(name string) (*Service, error) {
 		return nil, errors.Wrap(err, "failed to fabricnats.New")
 	}
 
-	r, err := f.Replayer("store", true)
+	return NewWithFabric(name, f)
+}
+
+// NewWithFabric creates a Service with a SQLite store using the provided fabric,
+// such as fabricmem for tests and single-process applications.
+func NewWithFabric(name string, f fabric.Fabric) (*Service, error) {
+	r, err := f.Replayer(context.Background(), "store")
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to f.Replayer")
 	}
 
+	snaps, err := f.Snapshots(context.Background(), "store")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to f.Snapshots")
+	}
+
+	acks, err := f.Broadcaster(context.Background(), "acks")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to f.Broadcaster")
+	}
+
+	checksums, err := f.Broadcaster(context.Background(), "checksums")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to f.Broadcaster")
+	}
+
 	d, err := driversqlite.New(name)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to driversqlite.New")
 	}
 
-	s := store.New(d, r)
+	opts, err := store.OptionsFromEnv()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to store.OptionsFromEnv")
+	}
+
+	opts.Snapshots = snaps
+	opts.Acks = acks
+	opts.Checksums = checksums
+
+	s := store.NewWithOptions(d, r, opts)
 
 	return NewWithFabricStore(name, f, s)
 }
@@ -57,9 +97,10 @@ func NewWithFabricStore(name string, fabric fabric.Fabric, store *store.Store) (
 
 // Serve takes in an App definition and begins serving the public and private handlers.
 // - App's public handler is served on an HTTP port defined by LIBSDK_PUBLIC_PORT, defaulting to :8080
-// - App's private handler is served using the configured fabric.
+// - App's private handler is served using the configured fabric, reachable from other services via Client.
 // - App's transaction handlers are registered for use by the store.
 // - App's migrations are applied to the store before replaying transactions.
+// Serve blocks until the Service is shut down, and returns nil after a call to Shutdown.
 func (s *Service) Serve(app App) error {
 	for name, handler := range app.Transactions() {
 		if err := s.store.Register(name, handler); err != nil {
@@ -71,22 +112,74 @@ func (s *Service) Serve(app App) error {
 		return errors.Wrap(err, "failed to store.Start")
 	}
 
-	server := &http.Server{
+	msgr, err := s.fabric.Messenger(s.name)
+	if err != nil {
+		return errors.Wrap(err, "failed to fabric.Messenger")
+	}
+
+	s.msgr = msgr
+
+	if err := servePrivate(s.name, msgr, app.Private(s.store), app.Log()); err != nil {
+		return errors.Wrap(err, "failed to servePrivate")
+	}
+
+	app.Log().Info("private server started", "service", s.name)
+
+	s.server = &http.Server{
 		Addr:    publicAddr(),
 		Handler: app.Public(s.store),
 	}
 
-	app.Log().Info("public server starting", "addr", server.Addr)
+	app.Log().Info("public server starting", "addr", s.server.Addr)
 
-	return server.ListenAndServe()
+	if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return errors.Wrap(err, "failed to ListenAndServe")
+	}
+
+	return nil
+}
+
+// Shutdown gracefully stops the public server, the private handler, the store, and the fabric.
+func (s *Service) Shutdown(ctx context.Context) error {
+	if s.server != nil {
+		if err := s.server.Shutdown(ctx); err != nil {
+			return errors.Wrap(err, "failed to server.Shutdown")
+		}
+	}
+
+	if s.msgr != nil {
+		if err := s.msgr.Close(); err != nil {
+			return errors.Wrap(err, "failed to msgr.Close")
+		}
+	}
+
+	if err := s.store.Stop(); err != nil {
+		return errors.Wrap(err, "failed to store.Stop")
+	}
+
+	if err := s.fabric.Close(); err != nil {
+		return errors.Wrap(err, "failed to fabric.Close")
+	}
+
+	return nil
 }
 
 // Store returns the Service's store, which should be used by handlers
-// to read and write from the replicated database via store.Exec.
+// to read and write from the replicated database via store.ExecContext.
 func (s *Service) Store() *store.Store {
 	return s.store
 }
 
+// Client returns an HTTP client that sends requests to other services' private handlers
+// over the fabric, using the URL's host as the service name, i.e. http://ROLES/path
+func (s *Service) Client() *http.Client {
+	c := &http.Client{
+		Transport: NewTransport(s.fabric),
+	}
+
+	return c
+}
+
 func publicAddr() string {
 	addr := ":8080"
 
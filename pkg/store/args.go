@@ -0,0 +1,106 @@
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TxArgs are the arguments passed to a TxHandler. When a TxRecord is replicated,
+// TxArgs are encoded with encoding/gob regardless of the fabric's codec, so that
+// handlers receive the same Go types at replay time as they did when the transaction
+// was executed (an int64 remains an int64, a struct remains that struct, etc).
+// Types other than Go's basic types must be registered with RegisterType.
+type TxArgs []any
+
+func init() {
+	RegisterType(time.Time{})
+}
+
+// RegisterType registers the type of value so that it can be used as a transaction
+// argument. Register value types, i.e. Person{} rather than &Person{}; pointer args
+// are received by handlers as the values they point to.
+func RegisterType(value any) {
+	gob.Register(value)
+}
+
+// MarshalBinary encodes the args using encoding/gob. Binary codecs such
+// as CBOR and MessagePack embed the result as a byte string.
+func (a TxArgs) MarshalBinary() ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	args := []any(a)
+
+	if err := gob.NewEncoder(buf).Encode(&args); err != nil {
+		return nil, errors.Wrap(err, "failed to gob Encode, ensure the type of each arg is registered with store.RegisterType")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes args encoded by MarshalBinary
+func (a *TxArgs) UnmarshalBinary(data []byte) error {
+	args := []any{}
+
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&args); err != nil {
+		return errors.Wrap(err, "failed to gob Decode")
+	}
+
+	*a = args
+
+	return nil
+}
+
+// MarshalJSON encodes the args as a base64 string of their binary encoding
+func (a TxArgs) MarshalJSON() ([]byte, error) {
+	data, err := a.MarshalBinary()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to MarshalBinary")
+	}
+
+	return json.Marshal(data)
+}
+
+// UnmarshalJSON decodes args encoded by MarshalJSON. Records written before
+// args were binary encoded contain a plain JSON array, which is decoded as-is.
+func (a *TxArgs) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		args := []any{}
+
+		if err := json.Unmarshal(data, &args); err != nil {
+			return errors.Wrap(err, "failed to json.Unmarshal legacy args")
+		}
+
+		*a = args
+
+		return nil
+	}
+
+	var bin []byte
+
+	if err := json.Unmarshal(data, &bin); err != nil {
+		return errors.Wrap(err, "failed to json.Unmarshal")
+	}
+
+	return a.UnmarshalBinary(bin)
+}
+
+// roundTrip encodes and decodes the args, producing the values that
+// replicas will receive when the transaction is replayed.
+func (a TxArgs) roundTrip() (TxArgs, error) {
+	data, err := a.MarshalBinary()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to MarshalBinary")
+	}
+
+	decoded := TxArgs{}
+
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		return nil, errors.Wrap(err, "failed to UnmarshalBinary")
+	}
+
+	return decoded, nil
+}
@@ -0,0 +1,136 @@
+package store
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// batchTxName is the name of batch records, which replicas that don't support batches fail to find a handler for
+const batchTxName TxName = "libsdk.batch"
+
+// TxCall is a call to a named transaction, to be executed as part of a batch by ExecBatch
+type TxCall struct {
+	Name TxName
+	Args []any
+}
+
+// BatchEntry is a transaction within a batch record
+type BatchEntry struct {
+	Name    TxName `json:"name"`
+	Version int    `json:"version,omitempty"`
+	Args    TxArgs `json:"args"`
+}
+
+// Call returns a call to the named transaction with the given args
+func Call(name TxName, args ...any) TxCall {
+	c := TxCall{
+		Name: name,
+		Args: args,
+	}
+
+	return c
+}
+
+// ExecBatch executes several transactions as one atomic unit, in the order given, like ExecContext. The
+// transactions run in a single driver transaction and are replicated as a single record, so that either
+// all of them are applied by every replica or none are. The results of the transactions are returned in order.
+func (s *Store) ExecBatch(ctx context.Context, calls ...TxCall) ([]any, error) {
+	return s.ExecBatchWith(ctx, ExecOptions{}, calls...)
+}
+
+// ExecBatchWith executes a batch like ExecBatch, replicating it with the consistency described by opts.
+func (s *Store) ExecBatchWith(ctx context.Context, opts ExecOptions, calls ...TxCall) ([]any, error) {
+	if len(calls) == 0 {
+		return nil, errors.New("batch contains no transactions")
+	}
+
+	entries := []BatchEntry{}
+
+	for _, call := range calls {
+		base, version, err := parseTxName(call.Name)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parseTxName")
+		}
+
+		_, version, err = s.handler(base, version, true)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to handler")
+		}
+
+		// each handler receives its args as they are decoded after replication
+		args, err := TxArgs(call.Args).roundTrip()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to encode args for transaction with name %s", call.Name)
+		}
+
+		entry := BatchEntry{
+			Name:    base,
+			Version: version,
+			Args:    args,
+		}
+
+		entries = append(entries, entry)
+	}
+
+	txRec := TxRecord{
+		Name:  batchTxName,
+		Batch: entries,
+	}
+
+	handler, err := s.recordHandler(&txRec)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to recordHandler")
+	}
+
+	result, err := s.execute(ctx, opts, txRec, handler)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to execute batch")
+	}
+
+	// the batch handler always returns its results as a slice
+	results, _ := result.([]any)
+
+	return results, nil
+}
+
+// recordHandler returns the handler for a record, which runs every transaction in the record's batch
+// if it has one. Records are always replayed by the version of the handler that executed them.
+func (s *Store) recordHandler(rec *TxRecord) (TxHandler, error) {
+	if len(rec.Batch) == 0 {
+		handler, _, err := s.handler(rec.Name, rec.Version, false)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to handler")
+		}
+
+		return handler, nil
+	}
+
+	handlers := []TxHandler{}
+
+	for _, entry := range rec.Batch {
+		handler, _, err := s.handler(entry.Name, entry.Version, false)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to handler")
+		}
+
+		handlers = append(handlers, handler)
+	}
+
+	batch := func(tx Tx, _ ...any) (any, error) {
+		results := []any{}
+
+		for i, handler := range handlers {
+			result, err := handler(tx, rec.Batch[i].Args...)
+			if err != nil {
+				return nil, errors.Wrapf(err, "transaction %d of batch with name %s failed", i, rec.Batch[i].Name)
+			}
+
+			results = append(results, result)
+		}
+
+		return results, nil
+	}
+
+	return batch, nil
+}
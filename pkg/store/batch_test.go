@@ -0,0 +1,62 @@
+package store_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	fabricmem "github.com/cohix/libsdk/pkg/fabric/fabric-mem"
+	"github.com/cohix/libsdk/pkg/store"
+)
+
+func TestBatchAtomic(t *testing.T) {
+	ctx := context.Background()
+	bus := fabricmem.NewBus()
+
+	for _, mode := range []string{"unordered", "ordered"} {
+		t.Run(mode, func(t *testing.T) {
+			configure := func(r *replica) {
+				r.opts.Ordered = mode == "ordered"
+			}
+
+			a := startReplica(t, bus, configure)
+			b := startReplica(t, bus, configure)
+
+			// the duplicate name fails the whole batch, so nothing in it is applied
+			_, err := a.ExecBatch(ctx,
+				store.Call(insertPerson, "rick-"+mode),
+				store.Call(appendEntry, mode),
+				store.Call(insertPerson, "rick-"+mode),
+			)
+
+			if err == nil {
+				t.Fatal("batch with a failing transaction succeeded")
+			}
+
+			results, err := a.ExecBatch(ctx,
+				store.Call(insertPerson, "morty-"+mode),
+				store.Call(appendEntry, mode),
+			)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(results) != 2 {
+				t.Fatalf("batch returned %d results, want 2", len(results))
+			}
+
+			eventually(t, func() bool { return b.entries(t, mode) > 0 }, "b to apply the batch")
+
+			for _, r := range []*replica{a, b} {
+				if names := r.names(t); slices.Contains(names, "rick-"+mode) || !slices.Contains(names, "morty-"+mode) {
+					t.Fatalf("replica has %v, want morty-%s but not rick-%s", names, mode, mode)
+				}
+
+				if count := r.entries(t, mode); count != 1 {
+					t.Fatalf("replica has %d entries from the batches, want 1", count)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package store
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrSubscriberLagging closes a subscription whose events weren't received as fast as they were
+	// applied. Subscribing again with After set to the highest sequence received resumes where it left off.
+	ErrSubscriberLagging = errors.New("subscriber fell behind and its buffer filled")
+
+	// ErrPositionUnavailable is returned by Subscribe when events after the requested position are no
+	// longer held by the store, in which case the subscriber should reload its data and subscribe afresh.
+	ErrPositionUnavailable = errors.New("events after the requested position are no longer available")
+
+	// ErrStoreStopped closes subscriptions when the store is stopped
+	ErrStoreStopped = errors.New("store stopped")
+)
+
+// defaultSubscriptionBuffer is the number of events buffered for a subscription by default
+const defaultSubscriptionBuffer = 256
+
+// ChangeReporter is implemented by driver transactions that can report the rows they changed
+type ChangeReporter interface {
+	// Changes returns the rows changed by the transaction, in the order they were changed
+	Changes() []RowChange
+}
+
+// RowChange is a row inserted, updated or deleted by a transaction
+type RowChange struct {
+	Op    string // insert, update or delete
+	Table string
+	RowID int64
+}
+
+// ChangeEvent describes a transaction applied to the store, whether it was executed locally or replayed.
+// Changes is empty if the driver doesn't report changes, or for a local transaction applied before a restart.
+type ChangeEvent struct {
+	Sequence uint64 // the transaction's position in the stream, used to resume a subscription
+	UUID     string
+	Name     TxName
+	Version  int
+	Args     TxArgs
+	Batch    []BatchEntry // the transactions in a batch, whose Name is libsdk.batch
+	Origin   string       // the instance that executed the transaction
+	Time     time.Time    // when the transaction was executed
+	Changes  []RowChange
+}
+
+// Filter selects the events delivered to a subscription, and where it starts
+type Filter struct {
+	Names  []TxName // only transactions with these names, including within batches, any if empty
+	Tables []string // only transactions that changed rows in these tables, any if empty
+
+	// After resumes a subscription after the event with this sequence, delivering the events since
+	// then that the store still holds, 0 to only deliver new events.
+	After uint64
+
+	// Buffer is the number of events held for the subscriber before it's considered to be lagging
+	// and its subscription is closed with ErrSubscriberLagging, defaulting to 256.
+	Buffer int
+}
+
+// Subscription delivers the events matching its filter until it's closed
+type Subscription struct {
+	store  *Store
+	filter Filter
+	events chan ChangeEvent
+	lock   sync.Mutex
+	err    error
+	closed bool
+}
+
+// Subscribe returns a subscription to the events matching filter. Events are delivered in the order
+// transactions are applied, and the apply loop never waits for subscribers, so a subscriber that
+// doesn't keep up has its subscription closed and must resume it from the highest sequence it received.
+// A transaction redriven from the dead letter queue is delivered when it's applied, with its original
+// sequence, which is lower than those of the events before it.
+func (s *Store) Subscribe(filter Filter) (*Subscription, error) {
+	if filter.Buffer <= 0 {
+		filter.Buffer = defaultSubscriptionBuffer
+	}
+
+	if err := s.outdatedErr(); err != nil {
+		return nil, err
+	}
+
+	s.feedLock.Lock()
+	defer s.feedLock.Unlock()
+
+	backlog := []ChangeEvent{}
+
+	if filter.After > 0 {
+		if filter.After < s.historyFrom {
+			return nil, ErrPositionUnavailable
+		}
+
+		for _, ev := range s.history {
+			if ev.Sequence > filter.After && filter.matches(ev) {
+				backlog = append(backlog, ev)
+			}
+		}
+	}
+
+	sub := &Subscription{
+		store:  s,
+		filter: filter,
+		events: make(chan ChangeEvent, max(filter.Buffer, len(backlog))),
+	}
+
+	for _, ev := range backlog {
+		sub.events <- ev
+	}
+
+	s.subscribers[sub] = true
+
+	return sub, nil
+}
+
+// Events returns the channel events are delivered on, which is closed when the subscription is
+func (sub *Subscription) Events() <-chan ChangeEvent {
+	return sub.events
+}
+
+// Err returns the reason the subscription was closed, or nil if it's open or was closed by Close
+func (sub *Subscription) Err() error {
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+
+	return sub.err
+}
+
+// Close closes the subscription
+func (sub *Subscription) Close() {
+	sub.store.feedLock.Lock()
+	defer sub.store.feedLock.Unlock()
+
+	sub.close(nil)
+}
+
+// close closes the subscription's channel with the given reason, the store's feedLock must be held
+func (sub *Subscription) close(err error) {
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+
+	if sub.closed {
+		return
+	}
+
+	sub.closed = true
+	sub.err = err
+	close(sub.events)
+
+	delete(sub.store.subscribers, sub)
+}
+
+// matches returns true if the event should be delivered to a subscription with the filter
+func (f Filter) matches(ev ChangeEvent) bool {
+	if len(f.Names) > 0 {
+		matched := slices.Contains(f.Names, ev.Name)
+
+		for _, entry := range ev.Batch {
+			matched = matched || slices.Contains(f.Names, entry.Name)
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	if len(f.Tables) > 0 {
+		return slices.ContainsFunc(ev.Changes, func(c RowChange) bool {
+			return slices.Contains(f.Tables, c.Table)
+		})
+	}
+
+	return true
+}
+
+// emit records the event for resuming subscriptions and delivers it to the matching subscribers,
+// closing the subscriptions of any whose buffer is full
+func (s *Store) emit(seq uint64, rec *TxRecord, changes []RowChange) {
+	ev := ChangeEvent{
+		Sequence: seq,
+		UUID:     rec.UUID,
+		Name:     rec.Name,
+		Version:  rec.Version,
+		Args:     rec.Args,
+		Batch:    rec.Batch,
+		Origin:   rec.Origin,
+		Time:     rec.Time,
+		Changes:  changes,
+	}
+
+	s.feedLock.Lock()
+	defer s.feedLock.Unlock()
+
+	if s.options.ChangeHistory > 0 && seq > s.historyFrom {
+		// history is kept in sequence order, as a transaction that's retried or redriven from
+		// the dead letter queue is applied after later ones, and one that's redelivered is held once
+		i, held := slices.BinarySearchFunc(s.history, seq, func(ev ChangeEvent, seq uint64) int {
+			return cmp.Compare(ev.Sequence, seq)
+		})
+
+		if held {
+			return
+		}
+
+		s.history = slices.Insert(s.history, i, ev)
+
+		if len(s.history) > s.options.ChangeHistory {
+			s.historyFrom = s.history[0].Sequence
+			s.history = s.history[1:]
+		}
+	} else if s.options.ChangeHistory <= 0 {
+		s.historyFrom = max(s.historyFrom, seq)
+	}
+
+	for sub := range s.subscribers {
+		if !sub.filter.matches(ev) {
+			continue
+		}
+
+		select {
+		case sub.events <- ev:
+		default:
+			sub.close(ErrSubscriberLagging)
+		}
+	}
+}
+
+// closeSubscriptions closes every subscription with the reason replay stopped
+func (s *Store) closeSubscriptions(reason error) {
+	s.feedLock.Lock()
+	defer s.feedLock.Unlock()
+
+	for sub := range s.subscribers {
+		sub.close(reason)
+	}
+}
+
+// changes returns the rows changed by the transaction, if the driver reports them
+func changes(tx Tx) []RowChange {
+	if reporter, ok := tx.(ChangeReporter); ok {
+		return reporter.Changes()
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package store_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	fabricmem "github.com/cohix/libsdk/pkg/fabric/fabric-mem"
+	"github.com/cohix/libsdk/pkg/store"
+)
+
+// next returns the subscription's next event, failing the test if there isn't one
+func next(t *testing.T, sub *store.Subscription) store.ChangeEvent {
+	t.Helper()
+
+	select {
+	case ev, ok := <-sub.Events():
+		if !ok {
+			t.Fatalf("subscription closed: %v", sub.Err())
+		}
+
+		return ev
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for event")
+	}
+
+	return store.ChangeEvent{}
+}
+
+func TestSubscribe(t *testing.T) {
+	bus := fabricmem.NewBus()
+
+	a := startReplica(t, bus, nil)
+	b := startReplica(t, bus, nil)
+
+	people, err := b.Subscribe(store.Filter{Tables: []string{"people"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.Exec(appendEntry, "first"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.Exec(insertPerson, "rick"); err != nil {
+		t.Fatal(err)
+	}
+
+	// replayed transactions carry the rows they changed
+	ev := next(t, people)
+
+	want := []store.RowChange{{Op: "insert", Table: "people", RowID: 1}}
+
+	if ev.Name != insertPerson || ev.Origin != a.opts.Instance || !slices.Equal(ev.Changes, want) {
+		t.Fatalf("event %+v, want insertPerson from a changing %v", ev, want)
+	}
+
+	// as do transactions executed locally
+	local, err := a.Subscribe(store.Filter{Names: []store.TxName{insertPerson}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.Exec(insertPerson, "morty"); err != nil {
+		t.Fatal(err)
+	}
+
+	ev = next(t, local)
+
+	want = []store.RowChange{{Op: "insert", Table: "people", RowID: 2}}
+
+	if !slices.Equal(ev.Changes, want) {
+		t.Fatalf("local event changed %v, want %v", ev.Changes, want)
+	}
+
+	// a subscription resumes after the last event it received
+	resumed, err := b.Subscribe(store.Filter{After: ev.Sequence - 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []store.TxName{insertPerson, insertPerson} {
+		if ev := next(t, resumed); ev.Name != name {
+			t.Fatalf("resumed event %s, want %s", ev.Name, name)
+		}
+	}
+
+	// a subscriber that doesn't keep up is closed rather than blocking replay
+	lagging, err := b.Subscribe(store.Filter{Buffer: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range []string{"second", "third"} {
+		if _, err := a.Exec(appendEntry, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	eventually(t, func() bool { return lagging.Err() != nil }, "lagging subscription to close")
+
+	if !errors.Is(lagging.Err(), store.ErrSubscriberLagging) {
+		t.Fatalf("lagging subscription closed with %v, want ErrSubscriberLagging", lagging.Err())
+	}
+}
@@ -0,0 +1,176 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Consistency is how widely a transaction must be replicated before Exec returns
+type Consistency int
+
+const (
+	// StreamDurable waits until the transaction is durably stored in the fabric and replayed by
+	// this replica. Other replicas will apply it, but may not have yet. This is the default.
+	StreamDurable Consistency = iota
+
+	// LocalOnly returns once the transaction is applied to this replica, publishing it in the
+	// background. If publishing fails, it's retried from the outbox when the driver is an Outbox.
+	LocalOnly
+
+	// ReplicaAcks waits until StreamDurable, and then until other replicas acknowledge that
+	// they've applied the transaction, as described by ExecOptions' Replicas and Regions.
+	ReplicaAcks
+)
+
+// ExecOptions configures a call to ExecWith
+type ExecOptions struct {
+	Consistency Consistency
+
+	// For ReplicaAcks, the number of replicas other than this one that must apply the transaction,
+	// and the regions in which at least one replica must apply it. This replica counts for its own region.
+	Replicas int
+	Regions  []string
+
+	// Timeout is the maximum time to wait for the transaction to be applied and replicated,
+	// defaulting to the store's ExecTimeout. The context's deadline applies if it's sooner.
+	Timeout time.Duration
+}
+
+// applyAck is broadcast by a replica once it has applied a transaction that requested acknowledgements
+type applyAck struct {
+	UUID     string `json:"uuid"`
+	Instance string `json:"instance"`
+	Region   string `json:"region"`
+}
+
+// ackWaiter collects the acknowledgements for a transaction until enough have been received
+type ackWaiter struct {
+	lock      sync.Mutex
+	replicas  int
+	regions   map[string]bool // regions still waiting for an acknowledgement
+	instances map[string]bool // instances that have acknowledged
+	done      chan struct{}
+	closed    bool
+}
+
+// withTimeout returns a context that times out after the options' timeout or the store's default, if any
+func (s *Store) withTimeout(ctx context.Context, o ExecOptions) (context.Context, context.CancelFunc) {
+	timeout := s.options.ExecTimeout
+	if o.Timeout > 0 {
+		timeout = o.Timeout
+	}
+
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// newAckWaiter creates a waiter for the acknowledgements required by opts, by a replica in region
+func newAckWaiter(opts ExecOptions, region string) *ackWaiter {
+	w := &ackWaiter{
+		replicas:  opts.Replicas,
+		regions:   map[string]bool{},
+		instances: map[string]bool{},
+		done:      make(chan struct{}),
+	}
+
+	for _, r := range opts.Regions {
+		if r != region {
+			w.regions[r] = true
+		}
+	}
+
+	w.check()
+
+	return w
+}
+
+// add records an acknowledgement, closing done once enough have been received
+func (w *ackWaiter) add(ack *applyAck) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.instances[ack.Instance] = true
+	delete(w.regions, ack.Region)
+
+	w.check()
+}
+
+// check closes done if enough acknowledgements have been received, the lock must be held
+func (w *ackWaiter) check() {
+	if w.closed || len(w.instances) < w.replicas || len(w.regions) > 0 {
+		return
+	}
+
+	w.closed = true
+	close(w.done)
+}
+
+// status describes the acknowledgements received so far
+func (w *ackWaiter) status() string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	regions := []string{}
+	for r := range w.regions {
+		regions = append(regions, r)
+	}
+
+	return fmt.Sprintf("%d replicas acknowledged of %d required, waiting for regions %v", len(w.instances), w.replicas, regions)
+}
+
+// wait waits until enough acknowledgements are received or ctx is done
+func (w *ackWaiter) wait(ctx context.Context) error {
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrapf(ctx.Err(), "failed waiting for acknowledgements, %s", w.status())
+	}
+}
+
+// acknowledge broadcasts that this replica has applied the transaction
+func (s *Store) acknowledge(ctx context.Context, rec *TxRecord) {
+	ack := &applyAck{
+		UUID:     rec.UUID,
+		Instance: s.options.Instance,
+		Region:   s.options.Region,
+	}
+
+	if err := s.options.Acks.Broadcast(ctx, ack); err != nil {
+		s.log.Warn(errors.Wrapf(err, "failed to Broadcast acknowledgement for transaction %s", rec.UUID).Error())
+	}
+}
+
+// receiveAcks passes acknowledgements to the waiters for their transactions until ctx is done
+func (s *Store) receiveAcks(ctx context.Context) error {
+	gen := func() any {
+		return &applyAck{}
+	}
+
+	recv := func(msg any) error {
+		ack := msg.(*applyAck)
+
+		if ack.Instance == s.options.Instance {
+			return nil
+		}
+
+		if waiter, exists := s.waiters.Load(ack.UUID); exists {
+			waiter.(*ackWaiter).add(ack)
+		}
+
+		return nil
+	}
+
+	if err := s.options.Acks.Subscribe(ctx, gen, recv); err != nil {
+		return errors.Wrap(err, "failed to Acks.Subscribe")
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package store_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	fabricmem "github.com/cohix/libsdk/pkg/fabric/fabric-mem"
+	"github.com/cohix/libsdk/pkg/store"
+)
+
+func TestReplicaAcks(t *testing.T) {
+	ctx := context.Background()
+	bus := fabricmem.NewBus()
+
+	inRegion := func(region string) func(r *replica) {
+		return func(r *replica) {
+			acks, err := r.fabric.Broadcaster(ctx, "acks")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r.opts.Acks = acks
+			r.opts.Region = region
+		}
+	}
+
+	// replicas in the same process must still be told apart by default
+	a := startReplica(t, bus, inRegion("us"))
+	b := startReplica(t, bus, inRegion("us"))
+	c := startReplica(t, bus, inRegion("eu"))
+
+	opts := store.ExecOptions{
+		Consistency: store.ReplicaAcks,
+		Replicas:    2,
+	}
+
+	if _, err := a.ExecWith(ctx, opts, insertPerson, "rick"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range []*replica{b, c} {
+		if names := r.names(t); !slices.Equal(names, []string{"rick"}) {
+			t.Fatalf("replica has %v once acknowledged, want [rick]", names)
+		}
+	}
+
+	opts = store.ExecOptions{
+		Consistency: store.ReplicaAcks,
+		Regions:     []string{"eu"},
+	}
+
+	if _, err := b.ExecWith(ctx, opts, insertPerson, "morty"); err != nil {
+		t.Fatal(err)
+	}
+
+	if names := c.names(t); !slices.Equal(names, []string{"morty", "rick"}) {
+		t.Fatalf("eu replica has %v once acknowledged, want [morty rick]", names)
+	}
+
+	// there aren't enough replicas to acknowledge
+	opts = store.ExecOptions{
+		Consistency: store.ReplicaAcks,
+		Replicas:    3,
+		Timeout:     time.Millisecond * 200,
+	}
+
+	if _, err := a.ExecWith(ctx, opts, insertPerson, "summer"); err == nil {
+		t.Fatal("ExecWith succeeded without enough replicas to acknowledge")
+	}
+}
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DeadLetter is a replicated transaction that this replica failed to apply after repeated attempts
+type DeadLetter struct {
+	ID       uint64    `json:"id"`
+	Sequence uint64    `json:"sequence"`
+	Error    string    `json:"error"`
+	Time     time.Time `json:"time"`
+	Record   *TxRecord `json:"record"`
+}
+
+// DeadLetters lists the transactions that this replica dead lettered after failing to apply.
+func (s *Store) DeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	letters, err := s.replayer.DeadLetters(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to replayer.DeadLetters")
+	}
+
+	dead := make([]DeadLetter, len(letters))
+
+	for i, l := range letters {
+		txRec := &TxRecord{}
+
+		if err := l.Decode(txRec); err != nil {
+			return nil, errors.Wrapf(err, "failed to Decode dead letter %d", l.ID)
+		}
+
+		dead[i] = DeadLetter{
+			ID:       l.ID,
+			Sequence: l.Sequence,
+			Error:    l.Error,
+			Time:     l.Time,
+			Record:   txRec,
+		}
+	}
+
+	return dead, nil
+}
+
+// Redrive applies the dead lettered transaction with the given ID to this replica,
+// such as after deploying a fix for the handler that failed. The dead letter is
+// removed once the transaction is applied successfully. Dead letters from other
+// replicas are refused, as those replicas may have applied the transaction already.
+func (s *Store) Redrive(ctx context.Context, id uint64) error {
+	if err := s.replayer.Redrive(ctx, id); err != nil {
+		return errors.Wrapf(err, "failed to replayer.Redrive %d", id)
+	}
+
+	return nil
+}
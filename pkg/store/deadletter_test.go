@@ -0,0 +1,133 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	fabricmem "github.com/cohix/libsdk/pkg/fabric/fabric-mem"
+	"github.com/cohix/libsdk/pkg/store"
+)
+
+func TestDeadLetterRedrive(t *testing.T) {
+	ctx := context.Background()
+	bus := fabricmem.NewBus()
+
+	a := startReplica(t, bus, nil)
+
+	// b has a bug in its handler, which fails to apply a transaction that a applied
+	broken := atomic.Bool{}
+	broken.Store(true)
+
+	dir := t.TempDir()
+
+	persistent := func(r *replica) {
+		r.driverOpts.Dir = dir
+		r.driverOpts.Persistent = true
+	}
+
+	b := startReplica(t, bus, func(r *replica) {
+		persistent(r)
+
+		insert := r.handlers[insertPerson]
+
+		r.handlers[insertPerson] = func(tx store.Tx, args ...any) (any, error) {
+			if broken.Load() {
+				return nil, errors.New("bug")
+			}
+
+			return insert(tx, args...)
+		}
+	})
+
+	if _, err := a.Exec(insertPerson, "rick"); err != nil {
+		t.Fatal(err)
+	}
+
+	// replay continues past the dead letter
+	if _, err := a.Exec(appendEntry, "after"); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, func() bool { return b.entries(t, "after") == 1 }, "b to apply the transaction after the dead letter")
+
+	letters, err := b.DeadLetters(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(letters) != 1 || letters[0].Record.Name != insertPerson {
+		t.Fatalf("b has dead letters %+v, want the failed insertPerson", letters)
+	}
+
+	// only the replica that failed the transaction lists it and can redrive it
+	aLetters, err := a.DeadLetters(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(aLetters) != 0 {
+		t.Fatalf("a has dead letters %+v, want none", aLetters)
+	}
+
+	if err := a.Redrive(ctx, letters[0].ID); err == nil {
+		t.Fatal("a redrove b's dead letter")
+	}
+
+	if names := a.names(t); !slices.Equal(names, []string{"rick"}) {
+		t.Fatalf("a has %v after refusing to redrive, want [rick]", names)
+	}
+
+	sub, err := b.Subscribe(store.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// once the bug is fixed, the transaction is applied and the dead letter removed
+	broken.Store(false)
+
+	redriven := letters[0]
+
+	if err := b.Redrive(ctx, redriven.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if names := b.names(t); !slices.Equal(names, []string{"rick"}) {
+		t.Fatalf("b has %v after redriving, want [rick]", names)
+	}
+
+	letters, err = b.DeadLetters(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(letters) != 0 {
+		t.Fatalf("b has dead letters %+v after redriving, want none", letters)
+	}
+
+	// the redriven transaction is delivered with its original sequence, before the one after it
+	if ev := next(t, sub); ev.Name != insertPerson || ev.Sequence != redriven.Sequence {
+		t.Fatalf("received event %+v after redriving, want insertPerson with sequence %d", ev, redriven.Sequence)
+	}
+
+	// the redrive didn't move b's position back, so nothing is applied again when it restarts
+	if err := b.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := startReplica(t, bus, persistent)
+
+	if applied := restarted.applied.Load(); applied != 0 {
+		t.Fatalf("restarted replica applied %d transactions, want 0", applied)
+	}
+
+	if count := restarted.entries(t, "after"); count != 1 {
+		t.Fatalf("restarted replica has %d entries after the dead letter, want 1", count)
+	}
+
+	if names := restarted.names(t); !slices.Equal(names, []string{"rick"}) {
+		t.Fatalf("restarted replica has %v, want [rick]", names)
+	}
+}
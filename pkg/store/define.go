@@ -0,0 +1,133 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// Definition is a transaction whose input and result are typed. Its input is encoded
+// for replication by the Definition, so In doesn't need to be registered with RegisterType,
+// although any interface values it contains do.
+type Definition[In, Out any] struct {
+	name    TxName
+	handler func(tx Tx, in In) (Out, error)
+	store   atomic.Pointer[Store]
+}
+
+// Define defines a typed transaction with the given name, which can include a version as with Register.
+// It must be registered with a store using Register before it can be executed. A Definition's input is
+// encoded differently to a TxHandler's args, so replacing a handler with a Definition requires a new version.
+func Define[In, Out any](name TxName, handler func(tx Tx, in In) (Out, error)) *Definition[In, Out] {
+	d := &Definition[In, Out]{
+		name:    name,
+		handler: handler,
+	}
+
+	return d
+}
+
+// Name returns the transaction's name
+func (d *Definition[In, Out]) Name() TxName {
+	return d.name
+}
+
+// Handler returns a TxHandler that decodes the transaction's input and calls its typed handler
+func (d *Definition[In, Out]) Handler() TxHandler {
+	return func(tx Tx, args ...any) (any, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("transaction %s expects 1 encoded arg, got %d", d.name, len(args))
+		}
+
+		data, ok := args[0].([]byte)
+		if !ok {
+			return nil, fmt.Errorf("transaction %s expects an encoded arg, got %T", d.name, args[0])
+		}
+
+		var in In
+
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&in); err != nil {
+			return nil, errors.Wrapf(err, "failed to gob Decode input for transaction %s", d.name)
+		}
+
+		return d.handler(tx, in)
+	}
+}
+
+// Register registers the transaction's Handler with the store, which Exec and ExecWith then use.
+// A Definition can only be registered with one store.
+func (d *Definition[In, Out]) Register(s *Store) error {
+	if !d.store.CompareAndSwap(nil, s) {
+		return fmt.Errorf("transaction %s is already registered with a store", d.name)
+	}
+
+	if err := s.Register(d.name, d.Handler()); err != nil {
+		d.store.Store(nil)
+		return errors.Wrap(err, "failed to Register")
+	}
+
+	return nil
+}
+
+// Call returns a call to the transaction with the given input, to be executed as part of a batch by ExecBatch.
+// The transaction's result is the element of ExecBatch's results at the same position as the call.
+func (d *Definition[In, Out]) Call(in In) (TxCall, error) {
+	data, err := d.encode(in)
+	if err != nil {
+		return TxCall{}, errors.Wrap(err, "failed to encode")
+	}
+
+	return Call(d.name, data), nil
+}
+
+// Exec executes the transaction with its store as ExecContext does, returning its typed result
+func (d *Definition[In, Out]) Exec(ctx context.Context, in In) (Out, error) {
+	return d.ExecWith(ctx, ExecOptions{}, in)
+}
+
+// ExecWith executes the transaction with its store as ExecWith does, returning its typed result
+func (d *Definition[In, Out]) ExecWith(ctx context.Context, opts ExecOptions, in In) (Out, error) {
+	var out Out
+
+	s := d.store.Load()
+	if s == nil {
+		return out, fmt.Errorf("transaction %s is not registered with a store", d.name)
+	}
+
+	data, err := d.encode(in)
+	if err != nil {
+		return out, errors.Wrap(err, "failed to encode")
+	}
+
+	result, err := s.ExecWith(ctx, opts, d.name, data)
+	if err != nil {
+		return out, errors.Wrap(err, "failed to ExecWith")
+	}
+
+	// a nil result is the zero value of an interface or pointer Out
+	if result == nil {
+		return out, nil
+	}
+
+	out, ok := result.(Out)
+	if !ok {
+		return out, fmt.Errorf("transaction %s returned %T, expected %T", d.name, result, out)
+	}
+
+	return out, nil
+}
+
+// encode encodes the transaction's input as its single arg
+func (d *Definition[In, Out]) encode(in In) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	if err := gob.NewEncoder(buf).Encode(&in); err != nil {
+		return nil, errors.Wrapf(err, "failed to gob Encode input for transaction %s", d.name)
+	}
+
+	return buf.Bytes(), nil
+}
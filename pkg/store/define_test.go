@@ -0,0 +1,74 @@
+package store_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	fabricmem "github.com/cohix/libsdk/pkg/fabric/fabric-mem"
+	"github.com/cohix/libsdk/pkg/store"
+)
+
+type person struct {
+	Name string
+}
+
+// definePerson defines the typed version of insertPerson
+func definePerson() *store.Definition[person, int64] {
+	return store.Define(insertPerson.Version(2), func(tx store.Tx, p person) (int64, error) {
+		return tx.ReadWrite().Exec("INSERT INTO people (name) VALUES ($1)", p.Name)
+	})
+}
+
+func TestDefine(t *testing.T) {
+	ctx := context.Background()
+	bus := fabricmem.NewBus()
+
+	a := startReplica(t, bus, nil)
+
+	if _, err := a.Exec(insertPerson, "beth"); err != nil {
+		t.Fatal(err)
+	}
+
+	insert := definePerson()
+
+	if _, err := insert.Exec(ctx, person{Name: "jerry"}); err == nil {
+		t.Fatal("Exec succeeded before the definition was registered")
+	}
+
+	// records executed before the definition replay through the version they were executed with
+	b := startReplica(t, bus, func(r *replica) {
+		r.register = append(r.register, insert.Register)
+	})
+
+	id, err := insert.Exec(ctx, person{Name: "morty"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id != 2 {
+		t.Fatalf("Exec returned id %d, want 2", id)
+	}
+
+	call, err := insert.Call(person{Name: "rick"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := b.ExecBatch(ctx, call)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if results[0] != int64(3) {
+		t.Fatalf("ExecBatch returned %v, want [3]", results)
+	}
+
+	if names := b.names(t); !slices.Equal(names, []string{"beth", "morty", "rick"}) {
+		t.Fatalf("replica has %v, want [beth morty rick]", names)
+	}
+
+	if err := insert.Register(a.Store); err == nil {
+		t.Fatal("definition was registered with a second store")
+	}
+}
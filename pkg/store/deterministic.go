@@ -0,0 +1,91 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+)
+
+// Deterministic provides the time, randomness and IDs for a transaction, derived from its TxRecord
+// so that every replica replaying the transaction sees the same values as the replica that executed it.
+// Drivers embed it in their Tx implementation using NewDeterministic.
+type Deterministic struct {
+	now  time.Time
+	seed int64
+	rand *rand.Rand
+	gen  *uuid.Gen
+}
+
+// NewDeterministic creates a Deterministic for the transaction described by rec
+func NewDeterministic(rec TxRecord) *Deterministic {
+	d := &Deterministic{
+		now:  rec.Time,
+		seed: rec.Seed,
+	}
+
+	return d
+}
+
+// Now returns the time at which the transaction was originally executed. Records
+// written before the time was captured return the zero time.
+func (d *Deterministic) Now() time.Time {
+	return d.now
+}
+
+// Rand returns a source of randomness seeded by the transaction,
+// which produces the same sequence of values on every replica
+func (d *Deterministic) Rand() *rand.Rand {
+	if d.rand == nil {
+		d.rand = rand.New(rand.NewSource(d.seed))
+	}
+
+	return d.rand
+}
+
+// NewUUID returns a V7 UUID for the transaction's time, using the transaction's
+// randomness, which produces the same sequence of UUIDs on every replica
+func (d *Deterministic) NewUUID() (uuid.UUID, error) {
+	if d.gen == nil {
+		d.gen = uuid.NewGenWithOptions(
+			uuid.WithEpochFunc(d.Now),
+			uuid.WithRandomReader(d.Rand()),
+		)
+	}
+
+	id, err := d.gen.NewV7()
+	if err != nil {
+		return uuid.Nil, errors.Wrap(err, "failed to NewV7")
+	}
+
+	return id, nil
+}
+
+// execDebug executes the transaction without committing it before executing it for real,
+// and logs an error if the results differ. Differing results mean the handler
+// depends on something other than its args, the database and the Deterministic helpers.
+func (s *Store) execDebug(ctx context.Context, seq uint64, rec TxRecord, handler TxHandler) (Tx, any, error) {
+	dryResult, dryErr := s.driver.(DryRunner).DryRun(ctx, rec, handler)
+
+	var tx Tx
+	var result any
+	var err error
+
+	if tracker, ok := s.driver.(Tracker); ok {
+		tx, result, err = tracker.ExecAt(ctx, seq, rec, handler)
+	} else {
+		tx, result, err = s.driver.Exec(ctx, rec, handler)
+	}
+
+	// the driver wraps handler errors, so only whether each run failed is compared
+	if !reflect.DeepEqual(dryResult, result) || (dryErr == nil) != (err == nil) {
+		s.log.Error("transaction handler is not deterministic, replicas may diverge when it is replayed",
+			"name", rec.Name, "uuid", rec.UUID, "dry_run", fmt.Sprintf("%v", dryResult), "result", fmt.Sprintf("%v", result))
+	}
+
+	return tx, result, err
+}
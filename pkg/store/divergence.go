@@ -0,0 +1,216 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrDiverged is returned by Exec and View once divergence is detected with HaltOnDivergence enabled
+var ErrDiverged = errors.New("store has diverged from another replica")
+
+// Digester is implemented by driver transactions that can summarise the rows they changed.
+// The digest of a transaction executed locally is published with it, and every replica
+// replaying the transaction compares it to its own, detecting replicas that have drifted apart.
+// In ordered mode, transactions are published before they're executed, so they carry no digest,
+// and divergence is only detected by comparing Checksums.
+type Digester interface {
+	// Digest returns a summary of the rows changed by the transaction, once it has been executed
+	Digest() string
+}
+
+// Checksummer is implemented by drivers that can checksum the contents of their database
+type Checksummer interface {
+	// Checksum returns a checksum of the application's data, excluding any the driver uses internally
+	Checksum() (string, error)
+}
+
+// Divergence describes a replica whose data was found to differ from this replica's. In the
+// default unordered mode, transactions written concurrently by different replicas to the same
+// rows are applied in a different order by the replica that executed them, and are also reported.
+type Divergence struct {
+	Sequence uint64 // the sequence of the transaction, or of the last transaction covered by the checksums
+	UUID     string // the transaction, empty if checksums differed
+	Name     TxName
+	Instance string // the replica whose digest or checksum differed
+	Expected string // the other replica's digest or checksum
+	Actual   string // this replica's digest or checksum
+}
+
+// dbChecksum is broadcast by a replica with the checksum of its database
+type dbChecksum struct {
+	Instance string `json:"instance"`
+	Sequence uint64 `json:"seq"`
+	Checksum string `json:"checksum"`
+}
+
+// verifyDigest compares the digest of a replayed transaction to the digest it was published with
+func (s *Store) verifyDigest(seq uint64, rec *TxRecord, tx Tx) {
+	digester, ok := tx.(Digester)
+	if !ok || rec.Digest == "" {
+		return
+	}
+
+	if digest := digester.Digest(); digest != rec.Digest {
+		s.diverge(Divergence{
+			Sequence: seq,
+			UUID:     rec.UUID,
+			Name:     rec.Name,
+			Instance: rec.Origin,
+			Expected: rec.Digest,
+			Actual:   digest,
+		})
+	}
+}
+
+// diverge reports a divergence, halting the store if HaltOnDivergence is enabled
+func (s *Store) diverge(d Divergence) {
+	s.log.Error("replica has diverged", "seq", d.Sequence, "uuid", d.UUID, "name", d.Name,
+		"instance", d.Instance, "expected", d.Expected, "actual", d.Actual)
+
+	if s.options.OnDivergence != nil {
+		s.options.OnDivergence(d)
+	}
+
+	if s.options.HaltOnDivergence && !s.diverged.Swap(true) {
+		s.log.Error("store halted after diverging, Exec and View will fail until it's restarted")
+	}
+}
+
+// Checksum computes and broadcasts a checksum of the database, comparing it to the checksums
+// of any replicas that had applied the same transactions. New transactions are blocked while
+// the checksum is computed.
+func (s *Store) Checksum(ctx context.Context) error {
+	checksummer, ok := s.driver.(Checksummer)
+	if !ok {
+		return errors.New("store driver does not support checksums")
+	}
+
+	if s.options.Checksums == nil {
+		return errors.New("store has no checksum broadcaster configured")
+	}
+
+	sum, err := s.computeChecksum(checksummer)
+	if err != nil {
+		return errors.Wrap(err, "failed to computeChecksum")
+	}
+
+	if err := s.options.Checksums.Broadcast(ctx, sum); err != nil {
+		return errors.Wrap(err, "failed to Checksums.Broadcast")
+	}
+
+	return nil
+}
+
+// computeChecksum checksums the database along with the sequence it covers, unless it's
+// already been checksummed at that sequence, and compares it to the peers' checksums
+func (s *Store) computeChecksum(checksummer Checksummer) (dbChecksum, error) {
+	s.execLock.Lock()
+	defer s.execLock.Unlock()
+
+	s.applyLock.Lock()
+	defer s.applyLock.Unlock()
+
+	s.checksumLock.Lock()
+	defer s.checksumLock.Unlock()
+
+	if s.checksum.Sequence == s.lastSeq && s.checksum.Checksum != "" {
+		return s.checksum, nil
+	}
+
+	checksum, err := checksummer.Checksum()
+	if err != nil {
+		return dbChecksum{}, errors.Wrap(err, "failed to driver Checksum")
+	}
+
+	s.checksum = dbChecksum{
+		Instance: s.options.Instance,
+		Sequence: s.lastSeq,
+		Checksum: checksum,
+	}
+
+	for _, peer := range s.peerChecksums {
+		s.compareChecksum(peer)
+	}
+
+	return s.checksum, nil
+}
+
+// compareChecksum reports a divergence if a peer's checksum at the same sequence as this replica's
+// latest checksum differs from it, the checksumLock must be held
+func (s *Store) compareChecksum(peer dbChecksum) {
+	if peer.Sequence != s.checksum.Sequence || peer.Checksum == s.checksum.Checksum {
+		return
+	}
+
+	s.diverge(Divergence{
+		Sequence: peer.Sequence,
+		Instance: peer.Instance,
+		Expected: peer.Checksum,
+		Actual:   s.checksum.Checksum,
+	})
+}
+
+// receiveChecksums compares the checksums broadcast by peers to this replica's until ctx is done. A replica
+// that has applied the same transactions as a peer, but hasn't checksummed them yet, does so immediately.
+func (s *Store) receiveChecksums(ctx context.Context) error {
+	gen := func() any {
+		return &dbChecksum{}
+	}
+
+	recv := func(msg any) error {
+		peer := msg.(*dbChecksum)
+
+		if peer.Instance == s.options.Instance {
+			return nil
+		}
+
+		s.checksumLock.Lock()
+		s.peerChecksums[peer.Instance] = *peer
+		s.compareChecksum(*peer)
+		checksummed := s.checksum.Sequence == peer.Sequence
+		s.checksumLock.Unlock()
+
+		if checksummed {
+			return nil
+		}
+
+		s.applyLock.Lock()
+		current := s.lastSeq == peer.Sequence
+		s.applyLock.Unlock()
+
+		if current {
+			go func() {
+				if err := s.Checksum(ctx); err != nil {
+					s.log.Error(errors.Wrap(err, "failed to Checksum").Error())
+				}
+			}()
+		}
+
+		return nil
+	}
+
+	if err := s.options.Checksums.Subscribe(ctx, gen, recv); err != nil {
+		return errors.Wrap(err, "failed to Checksums.Subscribe")
+	}
+
+	return nil
+}
+
+// checksumLoop computes and broadcasts a checksum every ChecksumInterval until ctx is cancelled
+func (s *Store) checksumLoop(ctx context.Context) {
+	ticker := time.NewTicker(s.options.ChecksumInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Checksum(ctx); err != nil {
+				s.log.Error(errors.Wrap(err, "failed to Checksum").Error())
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
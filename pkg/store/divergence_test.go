@@ -0,0 +1,63 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	fabricmem "github.com/cohix/libsdk/pkg/fabric/fabric-mem"
+	"github.com/cohix/libsdk/pkg/store"
+)
+
+func TestDigestDetectsDivergence(t *testing.T) {
+	bus := fabricmem.NewBus()
+
+	a := startReplica(t, bus, nil)
+
+	divergences := make(chan store.Divergence, 1)
+
+	// b's handler writes something different to a's for the same transaction
+	b := startReplica(t, bus, func(r *replica) {
+		r.opts.HaltOnDivergence = true
+		r.opts.OnDivergence = func(d store.Divergence) {
+			divergences <- d
+		}
+
+		r.handlers[insertPerson] = func(tx store.Tx, args ...any) (any, error) {
+			return tx.ReadWrite().Exec("INSERT INTO people (name) VALUES ($1 || '!')", args...)
+		}
+	})
+
+	// a transaction that both replicas apply identically doesn't diverge
+	if _, err := a.Exec(appendEntry, "same"); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, func() bool { return b.entries(t, "same") == 1 }, "b to apply the identical transaction")
+
+	if _, err := a.Exec(insertPerson, "rick"); err != nil {
+		t.Fatal(err)
+	}
+
+	var d store.Divergence
+
+	select {
+	case d = <-divergences:
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for divergence")
+	}
+
+	if d.Name != insertPerson || d.Instance != a.opts.Instance || d.Expected == d.Actual {
+		t.Fatalf("divergence %+v, want insertPerson from a with differing digests", d)
+	}
+
+	if _, err := b.Exec(appendEntry, "halted"); !errors.Is(err, store.ErrDiverged) {
+		t.Fatalf("Exec on the diverged replica returned %v, want ErrDiverged", err)
+	}
+
+	count := 0
+	if err := b.Get(context.Background(), &count, "SELECT count(*) FROM people"); !errors.Is(err, store.ErrDiverged) {
+		t.Fatalf("Get on the diverged replica returned %v, want ErrDiverged", err)
+	}
+}
@@ -0,0 +1,200 @@
+package driversqlite
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/cohix/libsdk/pkg/store"
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+var _ store.Digester = &Tx{}
+var _ store.ChangeReporter = &Tx{}
+var _ store.Checksummer = &Sqlite{}
+
+// change is a row changed by a transaction, as reported by SQLite's update hook
+type change struct {
+	op    int
+	table string
+	rowid int64
+}
+
+// changed is the update hook for a transaction's connection
+func (t *Tx) changed(op int, db string, table string, rowid int64) {
+	if db != "main" || internalTable(table) {
+		return
+	}
+
+	t.changes = append(t.changes, change{op: op, table: table, rowid: rowid})
+}
+
+// Digest returns the number of rows the transaction changed and a hash of their contents, as rows:hash.
+// SQLite does not report changes to tables created WITHOUT ROWID, or rows removed by a DELETE without
+// a WHERE clause, so they're not included, and divergence in them is only found by Checksum.
+func (t *Tx) Digest() string {
+	return t.digest
+}
+
+// digestChanges reads the current contents of every row changed by the transaction, in the order
+// they were first changed, and digests them. Rowids are not included in the digest, as they may be
+// assigned differently by a database restored from a snapshot for tables without an integer primary key.
+func (t *Tx) digestChanges() (string, error) {
+	order := []change{}
+	final := map[change]int{}
+
+	for _, c := range t.changes {
+		key := change{table: c.table, rowid: c.rowid}
+
+		if _, exists := final[key]; !exists {
+			order = append(order, key)
+		}
+
+		final[key] = c.op
+	}
+
+	h := sha256.New()
+
+	for _, key := range order {
+		if final[key] == sqlite3.SQLITE_DELETE {
+			fmt.Fprintf(h, "delete %s\n", key.table)
+			continue
+		}
+
+		rows, err := t.tx.QueryxContext(t.ctx, fmt.Sprintf("SELECT * FROM %q WHERE rowid = ?", key.table), key.rowid)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to read changed row from %s", key.table)
+		}
+
+		fmt.Fprintf(h, "row %s\n", key.table)
+
+		err = hashRows(h, rows)
+		rows.Close()
+
+		if err != nil {
+			return "", errors.Wrap(err, "failed to hashRows")
+		}
+	}
+
+	return fmt.Sprintf("%d:%x", len(order), h.Sum(nil)), nil
+}
+
+// Checksum returns a hash of the contents of every table in the database other than the driver's own,
+// with each table's rows sorted by all of their columns so that the order they're stored in doesn't matter
+func (s *Sqlite) Checksum() (string, error) {
+	tx, err := s.ro.Beginx()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to ro.Beginx")
+	}
+
+	defer tx.Rollback()
+
+	tables := []string{}
+
+	if err := tx.Select(&tables, "SELECT name FROM sqlite_master WHERE type = 'table' ORDER BY name"); err != nil {
+		return "", errors.Wrap(err, "failed to list tables")
+	}
+
+	h := sha256.New()
+
+	for _, table := range tables {
+		if strings.HasPrefix(table, "sqlite_") || internalTable(table) {
+			continue
+		}
+
+		columns, err := tableColumns(tx, table)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to tableColumns for %s", table)
+		}
+
+		order := []string{}
+		for i := range columns {
+			order = append(order, fmt.Sprint(i+1))
+		}
+
+		rows, err := tx.Queryx(fmt.Sprintf("SELECT * FROM %q ORDER BY %s", table, strings.Join(order, ", ")))
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to read table %s", table)
+		}
+
+		fmt.Fprintf(h, "table %s %s\n", table, strings.Join(columns, ","))
+
+		err = hashRows(h, rows)
+		rows.Close()
+
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to hashRows for %s", table)
+		}
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// tableColumns returns the names of a table's columns
+func tableColumns(tx *sqlx.Tx, table string) ([]string, error) {
+	rows, err := tx.Queryx(fmt.Sprintf("SELECT * FROM %q LIMIT 0", table))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to tx.Queryx")
+	}
+
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to rows.Columns")
+	}
+
+	return columns, nil
+}
+
+// hashRows writes the type and value of each column of each row to h
+func hashRows(h hash.Hash, rows *sqlx.Rows) error {
+	for rows.Next() {
+		values, err := rows.SliceScan()
+		if err != nil {
+			return errors.Wrap(err, "failed to rows.SliceScan")
+		}
+
+		for _, v := range values {
+			fmt.Fprintf(h, "%T:%v|", v, v)
+		}
+
+		fmt.Fprintln(h)
+	}
+
+	return rows.Err()
+}
+
+// internalTable returns true for the tables the driver uses to track transactions and migrations,
+// which differ between replicas and so are excluded from digests and checksums
+func internalTable(table string) bool {
+	return strings.HasPrefix(table, "libsdk_") || table == migrationsTable
+}
+
+// Changes returns the rows changed by the transaction, in the order they were changed.
+// SQLite does not report changes to tables created WITHOUT ROWID, or rows removed by a DELETE without a WHERE clause.
+func (t *Tx) Changes() []store.RowChange {
+	changes := []store.RowChange{}
+
+	for _, c := range t.changes {
+		rc := store.RowChange{
+			Op:    changeOps[c.op],
+			Table: c.table,
+			RowID: c.rowid,
+		}
+
+		changes = append(changes, rc)
+	}
+
+	return changes
+}
+
+// changeOps names the operations reported by SQLite's update hook
+var changeOps = map[int]string{
+	sqlite3.SQLITE_INSERT: "insert",
+	sqlite3.SQLITE_UPDATE: "update",
+	sqlite3.SQLITE_DELETE: "delete",
+}
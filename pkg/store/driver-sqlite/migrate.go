@@ -0,0 +1,147 @@
+package driversqlite
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cohix/libsdk/pkg/store"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// migrationsTable records the migrations applied to the database
+const migrationsTable = "schema_migrations"
+
+// appliedMigration is a row of the migrations table
+type appliedMigration struct {
+	Position int    `db:"position"`
+	Name     string `db:"name"`
+	Checksum string `db:"checksum"`
+	Applied  int64  `db:"applied"` // unix milliseconds
+}
+
+// Migrate applies the migrations that haven't been applied yet in a single transaction, recording each one
+// in the schema_migrations table, which is included in snapshots. The migrations already applied must be
+// the first of the migrations, in the same order and unedited, or an error is returned. If any migration
+// fails, the transaction is rolled back so that none of them are applied.
+func (s *Sqlite) Migrate(migrations []store.Migration) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return errors.Wrap(err, "failed to db.Beginx")
+	}
+
+	// rolling back after committing has no effect
+	defer tx.Rollback()
+
+	applied, err := appliedMigrations(tx, migrations)
+	if err != nil {
+		return errors.Wrap(err, "failed to appliedMigrations")
+	}
+
+	if len(applied) > len(migrations) {
+		return fmt.Errorf("database has %d migrations applied, but only %d are known", len(applied), len(migrations))
+	}
+
+	for i, a := range applied {
+		if a.Name != migrations[i].Name {
+			return fmt.Errorf("database has migration %s applied at position %d, but migration %s is in its place", a.Name, i, migrations[i].Name)
+		}
+
+		if a.Checksum != migrations[i].Checksum() {
+			return fmt.Errorf("migration %s has been edited since it was applied", a.Name)
+		}
+	}
+
+	for i := len(applied); i < len(migrations); i++ {
+		m := migrations[i]
+
+		s.log.Info("running migration", "name", m.Name, "num", i, "of", len(migrations))
+
+		if _, err := tx.Exec(m.SQL); err != nil {
+			return errors.Wrapf(err, "failed to run migration %s", m.Name)
+		}
+
+		if err := recordMigration(tx, i, m); err != nil {
+			return errors.Wrapf(err, "failed to recordMigration %s", m.Name)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "failed to tx.Commit")
+	}
+
+	return nil
+}
+
+// MigrationsApplied returns the number of migrations applied to the database, which is its
+// user_version if it's from before migrations were recorded
+func (s *Sqlite) MigrationsApplied() (int, error) {
+	exists := 0
+
+	if err := s.db.Get(&exists, "SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?", migrationsTable); err != nil {
+		return 0, errors.Wrap(err, "failed to check for migrations table")
+	}
+
+	query := fmt.Sprintf("SELECT count(*) FROM %s", migrationsTable)
+	if exists == 0 {
+		query = "PRAGMA user_version"
+	}
+
+	applied := 0
+
+	if err := s.db.Get(&applied, query); err != nil {
+		return 0, errors.Wrap(err, "failed to count applied migrations")
+	}
+
+	return applied, nil
+}
+
+// appliedMigrations returns the migrations applied to the database in the order they were applied,
+// creating the migrations table if needed. A database from before migrations were recorded has its
+// user_version set to the number applied, which are assumed to be the first of the migrations.
+func appliedMigrations(tx *sqlx.Tx, migrations []store.Migration) ([]appliedMigration, error) {
+	exists := 0
+
+	if err := tx.Get(&exists, "SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?", migrationsTable); err != nil {
+		return nil, errors.Wrap(err, "failed to check for migrations table")
+	}
+
+	if exists == 0 {
+		if _, err := tx.Exec(fmt.Sprintf("CREATE TABLE %s (position INTEGER PRIMARY KEY, name TEXT NOT NULL UNIQUE, checksum TEXT NOT NULL, applied INTEGER NOT NULL)", migrationsTable)); err != nil {
+			return nil, errors.Wrap(err, "failed to create migrations table")
+		}
+
+		version := 0
+
+		if err := tx.Get(&version, "PRAGMA user_version"); err != nil {
+			return nil, errors.Wrap(err, "failed to read user_version")
+		}
+
+		if version > len(migrations) {
+			return nil, fmt.Errorf("database has %d migrations applied, but only %d are known", version, len(migrations))
+		}
+
+		for i, m := range migrations[:version] {
+			if err := recordMigration(tx, i, m); err != nil {
+				return nil, errors.Wrapf(err, "failed to recordMigration %s", m.Name)
+			}
+		}
+	}
+
+	applied := []appliedMigration{}
+
+	if err := tx.Select(&applied, fmt.Sprintf("SELECT position, name, checksum, applied FROM %s ORDER BY position", migrationsTable)); err != nil {
+		return nil, errors.Wrap(err, "failed to read applied migrations")
+	}
+
+	return applied, nil
+}
+
+// recordMigration records that a migration has been applied at the given position
+func recordMigration(tx *sqlx.Tx, position int, m store.Migration) error {
+	if _, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (position, name, checksum, applied) VALUES (?, ?, ?, ?)", migrationsTable), position, m.Name, m.Checksum(), time.Now().UnixMilli()); err != nil {
+		return errors.Wrap(err, "failed to tx.Exec")
+	}
+
+	return nil
+}
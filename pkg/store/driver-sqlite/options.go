@@ -0,0 +1,51 @@
+package driversqlite
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// environment variables read by OptionsFromEnv
+const (
+	persistentEnvKey = "LIBSDK_STORE_PERSISTENT" // true to keep the database between restarts
+	dirEnvKey        = "LIBSDK_STORE_DIR"
+)
+
+// Options configures the SQLite driver
+type Options struct {
+	// Persistent keeps the database in SERVICE.sqlite between restarts, so that the store resumes
+	// replaying from the last transaction it applied. Otherwise, a new database is created for
+	// each run and removed when the driver is closed, and the store is rebuilt by replaying.
+	Persistent bool
+
+	// Dir is the directory databases are kept in, within a folder named for the service.
+	// Defaults to the user cache dir.
+	Dir string
+}
+
+// DefaultOptions returns Options for a non-persistent database in the user cache dir
+func DefaultOptions() Options {
+	o := Options{}
+
+	return o
+}
+
+// OptionsFromEnv returns DefaultOptions overridden by any LIBSDK_STORE_* environment variables
+func OptionsFromEnv() (Options, error) {
+	o := DefaultOptions()
+
+	if persistent, exists := os.LookupEnv(persistentEnvKey); exists {
+		p, err := strconv.ParseBool(persistent)
+		if err != nil {
+			return o, errors.Wrapf(err, "failed to parse %s", persistentEnvKey)
+		}
+
+		o.Persistent = p
+	}
+
+	o.Dir = os.Getenv(dirEnvKey)
+
+	return o, nil
+}
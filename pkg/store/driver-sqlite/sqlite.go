@@ -1,9 +1,17 @@
 package driversqlite
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 
 	"github.com/cohix/libsdk/pkg/store"
 	"github.com/gofrs/uuid"
@@ -16,25 +24,37 @@ import (
 	// "bad parameter or other API misuse: not an error (21)"
 	// but will revisit when there's more time to debug and we have
 	// to contend with the fun world of cross-compiling GCO.
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 var _ store.Driver = &Sqlite{}
+var _ store.Snapshotter = &Sqlite{}
+var _ store.Viewer = &Sqlite{}
+var _ store.DryRunner = &Sqlite{}
 
 // Sqlite is a SQLite driver for libsdk store
 type Sqlite struct {
-	db  *sqlx.DB
-	log slog.Logger
+	db         *sqlx.DB
+	ro         *sqlx.DB // read-only connection used by View
+	path       string
+	persistent bool
+	log        slog.Logger
 }
 
 type Tx struct {
+	*store.Deterministic
+	ctx      context.Context
+	conn     *sqlx.Conn
 	tx       *sqlx.Tx
 	didWrite bool
+	changes  []change // rows changed by the transaction, recorded by an update hook
+	digest   string
 }
 
 // ReadTx is a read-only transaction
 type ReadTx struct {
-	tx *sqlx.Tx
+	ctx context.Context
+	tx  *sqlx.Tx
 }
 
 // ReadWriteTx is a read-write transaction
@@ -42,35 +62,105 @@ type ReadWriteTx struct {
 	ReadTx
 }
 
-// New creates a new SQlite database on disk and a driver instance wrapping it.
+// New creates a new SQlite database on disk, configured by OptionsFromEnv, and a driver instance wrapping it.
 func New(serviceName string) (store.Driver, error) {
-	filepath, err := dbPath(serviceName)
+	opts, err := OptionsFromEnv()
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to dbPath")
+		return nil, errors.Wrap(err, "failed to OptionsFromEnv")
 	}
 
-	db, err := sqlx.Connect("sqlite3", fmt.Sprintf("%s?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)", filepath))
+	return NewWithOptions(serviceName, opts)
+}
+
+// NewWithOptions creates or opens a SQLite database on disk configured by opts, and a driver instance wrapping it.
+func NewWithOptions(serviceName string, opts Options) (*Sqlite, error) {
+	log := slog.With("lib", "libsdk", "pkg", "driversqlite")
+
+	filepath, err := dbPath(serviceName, opts)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to sqlx.Connect for path %s", filepath)
+		return nil, errors.Wrap(err, "failed to dbPath")
 	}
 
 	s := &Sqlite{
-		db:  db,
-		log: *slog.With("lib", "libsdk", "pkg", "driversqlite"),
+		path:       filepath,
+		persistent: opts.Persistent,
+		log:        *log,
 	}
 
-	s.log.Info("database created", "file", filepath)
+	if err := s.open(); err != nil {
+		return nil, errors.Wrap(err, "failed to open")
+	}
+
+	if opts.Persistent {
+		s.log.Info("database opened", "file", filepath)
+	} else {
+		s.log.Info("database created", "file", filepath)
+	}
 
 	return s, nil
 }
 
 // Exec executes a replayed transaction and returns its results
-func (s *Sqlite) Exec(rec store.TxRecord, handler store.TxHandler) (store.Tx, any, error) {
+func (s *Sqlite) Exec(ctx context.Context, rec store.TxRecord, handler store.TxHandler) (store.Tx, any, error) {
+	return s.exec(ctx, rec, handler, nil)
+}
+
+// View runs fn in a read-only transaction on the database's read-only connection
+func (s *Sqlite) View(ctx context.Context, fn func(tx store.ReadTx) error) error {
+	sqlxtx, err := s.ro.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return errors.Wrap(err, "failed to BeginTxx")
+	}
+
+	// nothing can be written, so the transaction is always rolled back
+	defer sqlxtx.Rollback()
+
+	r := &ReadTx{
+		ctx: ctx,
+		tx:  sqlxtx,
+	}
+
+	return fn(r)
+}
+
+// Close closes the database, removing it if it isn't persistent
+func (s *Sqlite) Close() error {
+	if err := s.close(); err != nil {
+		return errors.Wrap(err, "failed to close")
+	}
+
+	if s.persistent {
+		return nil
+	}
+
+	if err := removeDB(s.path); err != nil {
+		return errors.Wrap(err, "failed to removeDB")
+	}
+
+	return nil
+}
+
+// exec runs the handler in a transaction, calling track before committing if it's set.
+// The transaction is rolled back if ctx is cancelled before it's committed.
+func (s *Sqlite) exec(ctx context.Context, rec store.TxRecord, handler store.TxHandler, track func(tx *Tx) error) (store.Tx, any, error) {
 	s.log.Debug(fmt.Sprintf("exec name:%s uuid:%s", rec.Name, rec.UUID))
 
-	tx := s.tx()
+	tx, err := s.tx(ctx, rec)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to tx")
+	}
+
+	defer tx.release()
 
 	result, err := handler(tx, rec.Args...)
+	if err == nil && tx.didWrite {
+		tx.digest, err = tx.digestChanges()
+	}
+
+	if err == nil && track != nil {
+		err = track(tx)
+	}
+
 	if err != nil {
 		if rbErr := tx.tx.Rollback(); rbErr != nil {
 			return nil, nil, errors.Wrapf(rbErr, "failed to tx.Rollback after handler err %s", err.Error())
@@ -86,44 +176,178 @@ func (s *Sqlite) Exec(rec store.TxRecord, handler store.TxHandler) (store.Tx, an
 	return tx, result, err
 }
 
-// Migrate runs migration statements that must all succeed or an error is returned
-func (s *Sqlite) Migrate(statements []string) error {
-	tx, err := s.db.Begin()
+// Snapshot copies the database to a temporary file with VACUUM INTO and returns a reader
+// for the copy, which removes the file when it is closed
+func (s *Sqlite) Snapshot() (io.ReadCloser, error) {
+	path := fmt.Sprintf("%s.snapshot", s.path)
+
+	// VACUUM INTO fails if the file already exists, such as after a crash
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "failed to remove stale snapshot")
+	}
+
+	if _, err := s.db.Exec("VACUUM INTO ?", path); err != nil {
+		return nil, errors.Wrap(err, "failed to VACUUM INTO")
+	}
+
+	file, err := os.Open(path)
 	if err != nil {
-		return errors.Wrap(err, "failed to db.Begin")
+		return nil, errors.Wrap(err, "failed to os.Open snapshot")
 	}
 
-	for i, stmt := range statements {
-		s.log.Info("running migration", "num", i, "of", len(statements))
+	f := &snapshotFile{
+		File: file,
+	}
 
-		if _, err := tx.Exec(stmt); err != nil {
-			return errors.Wrap(err, "failed to tx.Exec")
+	return f, nil
+}
+
+// Restore replaces the database with a snapshot. The snapshot is written alongside
+// the database and checked before replacing it, so a failed restore leaves it intact,
+// and the database is reopened if replacing it fails.
+func (s *Sqlite) Restore(r io.Reader) error {
+	path := fmt.Sprintf("%s.restore", s.path)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to os.Create")
+	}
+
+	defer os.Remove(path)
+
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return errors.Wrap(err, "failed to io.Copy snapshot")
+	}
+
+	if err := file.Close(); err != nil {
+		return errors.Wrap(err, "failed to file.Close")
+	}
+
+	if err := check(path); err != nil {
+		return errors.Wrap(err, "failed to check snapshot")
+	}
+
+	if err := s.close(); err != nil {
+		return errors.Wrap(err, "failed to close")
+	}
+
+	if err := s.replace(path); err != nil {
+		if openErr := s.open(); openErr != nil {
+			return errors.Wrapf(openErr, "failed to reopen database after failing to replace it: %s", err.Error())
+		}
+
+		return errors.Wrap(err, "failed to replace")
+	}
+
+	if err := s.open(); err != nil {
+		return errors.Wrap(err, "failed to open")
+	}
+
+	s.log.Info("database restored", "file", s.path)
+
+	return nil
+}
+
+// replace moves the database at path over the closed database. Closing the database checkpoints
+// its write-ahead log, so the log is removed first and the database is intact until it's replaced.
+func (s *Sqlite) replace(path string) error {
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(s.path + suffix); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "failed to remove %s", s.path+suffix)
 		}
 	}
 
-	if err := tx.Commit(); err != nil {
-		return errors.Wrap(err, "failed to tx.Commit")
+	if err := os.Rename(path, s.path); err != nil {
+		return errors.Wrap(err, "failed to os.Rename")
 	}
 
 	return nil
 }
 
-// tx returns a driver-compatible transaction
-func (s *Sqlite) tx() *Tx {
-	sqlxtx := s.db.MustBegin()
+// DryRun executes the handler in a transaction that is always rolled back
+func (s *Sqlite) DryRun(ctx context.Context, rec store.TxRecord, handler store.TxHandler) (any, error) {
+	tx, err := s.tx(ctx, rec)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to tx")
+	}
+
+	defer tx.release()
+
+	result, err := handler(tx, rec.Args...)
+
+	if rbErr := tx.tx.Rollback(); rbErr != nil {
+		return nil, errors.Wrap(rbErr, "failed to tx.Rollback")
+	}
+
+	return result, err
+}
+
+// tx begins a driver-compatible transaction for the given record on its own connection,
+// which records the rows changed until the transaction is released
+func (s *Sqlite) tx(ctx context.Context, rec store.TxRecord) (*Tx, error) {
+	conn, err := s.db.Connx(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to db.Connx")
+	}
 
 	t := &Tx{
-		tx:       sqlxtx,
-		didWrite: false,
+		Deterministic: store.NewDeterministic(rec),
+		ctx:           ctx,
+		conn:          conn,
+		didWrite:      false,
+	}
+
+	if err := t.hook(t.changed); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "failed to hook")
+	}
+
+	sqlxtx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		t.release()
+		return nil, errors.Wrap(err, "failed to BeginTxx")
+	}
+
+	t.tx = sqlxtx
+
+	return t, nil
+}
+
+// hook sets the update hook of the transaction's connection, nil to remove it
+func (t *Tx) hook(fn func(op int, db string, table string, rowid int64)) error {
+	return t.conn.Raw(func(driverConn any) error {
+		conn, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+
+		conn.RegisterUpdateHook(fn)
+
+		return nil
+	})
+}
+
+// release removes the update hook and returns the connection to the pool, once the transaction is done
+func (t *Tx) release() {
+	// a connection whose hook can't be removed could record changes for a later transaction
+	if err := t.hook(nil); err != nil {
+		t.conn.Raw(func(any) error { return driver.ErrBadConn })
 	}
 
-	return t
+	t.conn.Close()
+}
+
+// Context returns the context the transaction is executing in
+func (t *Tx) Context() context.Context {
+	return t.ctx
 }
 
 // Read returns a read-only transaction
 func (t *Tx) Read() store.ReadTx {
 	r := &ReadTx{
-		tx: t.tx,
+		ctx: t.ctx,
+		tx:  t.tx,
 	}
 
 	return r
@@ -135,7 +359,8 @@ func (t *Tx) ReadWrite() store.ReadWriteTx {
 
 	rw := &ReadWriteTx{
 		ReadTx{
-			tx: t.tx,
+			ctx: t.ctx,
+			tx:  t.tx,
 		},
 	}
 
@@ -149,7 +374,7 @@ func (t *Tx) DidWrite() bool {
 
 // Select runs a query to select one or more rows and read them into out.
 func (r *ReadTx) Select(out any, query string, args ...any) error {
-	if err := r.tx.Select(out, query, args...); err != nil {
+	if err := r.tx.SelectContext(r.ctx, out, query, args...); err != nil {
 		return errors.Wrap(err, "failed to tx.Select")
 	}
 
@@ -158,7 +383,7 @@ func (r *ReadTx) Select(out any, query string, args ...any) error {
 
 // Get runs a query to select a single row and read it into out.
 func (r *ReadTx) Get(out any, query string, args ...any) error {
-	if err := r.tx.Get(out, query, args...); err != nil {
+	if err := r.tx.GetContext(r.ctx, out, query, args...); err != nil {
 		return errors.Wrap(err, "failed to tx.Get")
 	}
 
@@ -168,7 +393,7 @@ func (r *ReadTx) Get(out any, query string, args ...any) error {
 // Exec runs the provided query with the provided args and returns the insert ID, if any
 // Exec should be used for any insert, update, or delete queries.
 func (rw *ReadWriteTx) Exec(query string, args ...any) (int64, error) {
-	result, err := rw.tx.Exec(query, args...)
+	result, err := rw.tx.ExecContext(rw.ctx, query, args...)
 	if err != nil {
 		return 0, errors.Wrap(err, "failed to tx.Exec")
 	}
@@ -181,26 +406,180 @@ func (rw *ReadWriteTx) Exec(query string, args ...any) (int64, error) {
 	return id, nil
 }
 
-func dbPath(serviceName string) (string, error) {
-	config, err := os.UserCacheDir()
+// open opens the read-write and read-only connections to the database, and creates the
+// tracking tables if needed, which snapshots from replicas that didn't track won't have
+func (s *Sqlite) open() error {
+	db, err := connect(s.path)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to UserCacheDir")
+		return errors.Wrap(err, "failed to connect")
 	}
 
-	folder := fmt.Sprintf("%s/libsdk/%s", config, serviceName)
+	// the write-ahead log allows reads on the read-only connection to run alongside writes
+	ro, err := sqlx.Connect("sqlite3", fmt.Sprintf("file:%s?mode=ro&_query_only=1&_busy_timeout=5000", s.path))
+	if err != nil {
+		db.Close()
+		return errors.Wrapf(err, "failed to sqlx.Connect read-only for path %s", s.path)
+	}
+
+	s.db = db
+	s.ro = ro
+
+	if err := s.track(); err != nil {
+		s.close()
+		return errors.Wrap(err, "failed to track")
+	}
+
+	return nil
+}
+
+// close closes both connections to the database
+func (s *Sqlite) close() error {
+	if err := s.ro.Close(); err != nil {
+		return errors.Wrap(err, "failed to ro.Close")
+	}
+
+	if err := s.db.Close(); err != nil {
+		return errors.Wrap(err, "failed to db.Close")
+	}
+
+	return nil
+}
+
+// connect opens a read-write connection to the database at filepath. The write-ahead log is needed for
+// View's reads to run alongside writes, and foreign keys are left unenforced, as they always have been.
+func connect(filepath string) (*sqlx.DB, error) {
+	db, err := sqlx.Connect("sqlite3", fmt.Sprintf("%s?_journal_mode=WAL&_busy_timeout=5000", filepath))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to sqlx.Connect for path %s", filepath)
+	}
+
+	return db, nil
+}
+
+// check returns an error if the database at filepath is not a valid SQLite database
+func check(filepath string) error {
+	db, err := connect(filepath)
+	if err != nil {
+		return errors.Wrap(err, "failed to connect")
+	}
+
+	defer db.Close()
+
+	result := ""
+
+	if err := db.Get(&result, "PRAGMA quick_check"); err != nil {
+		return errors.Wrap(err, "failed to quick_check")
+	}
+
+	if result != "ok" {
+		return fmt.Errorf("quick_check failed: %s", result)
+	}
+
+	return nil
+}
+
+// snapshotFile is a snapshot's temporary file, removed when it is closed
+type snapshotFile struct {
+	*os.File
+}
+
+// Close closes and removes the file
+func (f *snapshotFile) Close() error {
+	if err := f.File.Close(); err != nil {
+		return errors.Wrap(err, "failed to file.Close")
+	}
+
+	if err := os.Remove(f.Name()); err != nil {
+		return errors.Wrap(err, "failed to os.Remove")
+	}
+
+	return nil
+}
+
+// dbPath returns the path of the service's database. A persistent database is kept in SERVICE.sqlite,
+// and otherwise a new database is created for each run. Non-persistent databases left behind by
+// processes that have exited without closing them are removed.
+func dbPath(serviceName string, opts Options) (string, error) {
+	dir := opts.Dir
+	if dir == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return "", errors.Wrap(err, "failed to UserCacheDir")
+		}
+
+		dir = fmt.Sprintf("%s/libsdk", cache)
+	}
+
+	folder := fmt.Sprintf("%s/%s", dir, serviceName)
 
 	if err := os.MkdirAll(folder, os.ModePerm); err != nil {
 		return "", errors.Wrap(err, "failed to MkdirAll")
 	}
 
+	if err := removeStale(folder, serviceName); err != nil {
+		return "", errors.Wrap(err, "failed to removeStale")
+	}
+
+	if opts.Persistent {
+		return fmt.Sprintf("%s/%s.sqlite", folder, serviceName), nil
+	}
+
 	dbUUID, err := uuid.NewV7()
 	if err != nil {
 		return "", errors.Wrap(err, "failed to uuid.NewV7")
 	}
 
 	// each time the service starts up, it's going to re-create the db from scratch
-	// by replaying from the fabric, so each time we create a new db to ensure it's fresh
-	dir := fmt.Sprintf("%s/%s-%s.sqlite", folder, serviceName, dbUUID.String())
+	// by replaying from the fabric, so each time we create a new db to ensure it's fresh.
+	// The process ID in its name allows it to be removed if the process exits without closing it.
+	path := fmt.Sprintf("%s/%s-%s.%d.sqlite", folder, serviceName, dbUUID.String(), os.Getpid())
+
+	return path, nil
+}
 
-	return dir, nil
+// removeStale removes the non-persistent databases in folder whose processes have exited.
+// Databases named without a process ID were created by earlier versions, and are removed.
+func removeStale(folder, serviceName string) error {
+	stale, err := filepath.Glob(fmt.Sprintf("%s/%s-*.sqlite", folder, serviceName))
+	if err != nil {
+		return errors.Wrap(err, "failed to Glob")
+	}
+
+	for _, path := range stale {
+		name := strings.TrimSuffix(filepath.Base(path), ".sqlite")
+
+		if dot := strings.LastIndex(name, "."); dot >= 0 {
+			pid, err := strconv.Atoi(name[dot+1:])
+			if err != nil || !exited(pid) {
+				continue
+			}
+		}
+
+		if err := removeDB(path); err != nil {
+			return errors.Wrap(err, "failed to removeDB")
+		}
+	}
+
+	return nil
+}
+
+// exited returns true if the process with the given ID is known to have exited
+func exited(pid int) bool {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	return errors.Is(proc.Signal(syscall.Signal(0)), os.ErrProcessDone)
+}
+
+// removeDB removes the database at path along with its write-ahead log, if they exist
+func removeDB(path string) error {
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if err := os.Remove(path + suffix); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "failed to remove %s", path+suffix)
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,136 @@
+package driversqlite
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/cohix/libsdk/pkg/store"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+var _ store.Tracker = &Sqlite{}
+var _ store.Outbox = &Sqlite{}
+
+// tables used to track which transactions have been applied, created
+// before migrations run so that they're included in snapshots
+var trackerStatements = []string{
+	"CREATE TABLE IF NOT EXISTS libsdk_position (id INTEGER PRIMARY KEY CHECK (id = 0), seq INTEGER NOT NULL)",
+	"INSERT OR IGNORE INTO libsdk_position (id, seq) VALUES (0, 0)",
+	"CREATE TABLE IF NOT EXISTS libsdk_outbox (uuid TEXT PRIMARY KEY, record BLOB NOT NULL, created INTEGER NOT NULL)",
+}
+
+// Position returns the sequence of the last replayed transaction applied to the database
+func (s *Sqlite) Position() (uint64, error) {
+	seq := uint64(0)
+
+	if err := s.db.Get(&seq, "SELECT seq FROM libsdk_position WHERE id = 0"); err != nil {
+		return 0, errors.Wrap(err, "failed to db.Get")
+	}
+
+	return seq, nil
+}
+
+// ExecAt executes a transaction and records it as applied in the same database transaction.
+// A replayed transaction's seq becomes the database's position, and a transaction executed
+// locally before being replayed, with seq 0, is added to the outbox if it wrote anything.
+func (s *Sqlite) ExecAt(ctx context.Context, seq uint64, rec store.TxRecord, handler store.TxHandler) (store.Tx, any, error) {
+	return s.exec(ctx, rec, handler, func(tx *Tx) error {
+		if seq > 0 {
+			return setPosition(tx.tx, seq)
+		}
+
+		if !tx.didWrite {
+			return nil
+		}
+
+		rec.Digest = tx.digest
+
+		record, err := json.Marshal(rec)
+		if err != nil {
+			return errors.Wrap(err, "failed to json.Marshal record")
+		}
+
+		if _, err := tx.tx.Exec("INSERT INTO libsdk_outbox (uuid, record, created) VALUES (?, ?, ?)", rec.UUID, record, time.Now().UnixMilli()); err != nil {
+			return errors.Wrap(err, "failed to add transaction to outbox")
+		}
+
+		return nil
+	})
+}
+
+// MarkApplied returns true if the transaction with the given UUID was already executed locally,
+// in which case it's removed from the outbox and seq becomes the database's position
+func (s *Sqlite) MarkApplied(seq uint64, uuid string) (bool, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to db.Beginx")
+	}
+
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM libsdk_outbox WHERE uuid = ?", uuid)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to tx.Exec")
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to RowsAffected")
+	}
+
+	if deleted == 0 {
+		return false, nil
+	}
+
+	if err := setPosition(tx, seq); err != nil {
+		return false, errors.Wrap(err, "failed to setPosition")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, errors.Wrap(err, "failed to tx.Commit")
+	}
+
+	return true, nil
+}
+
+// Pending returns the transactions in the outbox that were executed before the given time
+func (s *Sqlite) Pending(before time.Time) ([]store.TxRecord, error) {
+	rows := [][]byte{}
+
+	if err := s.db.Select(&rows, "SELECT record FROM libsdk_outbox WHERE created < ? ORDER BY created", before.UnixMilli()); err != nil {
+		return nil, errors.Wrap(err, "failed to db.Select")
+	}
+
+	records := make([]store.TxRecord, len(rows))
+
+	for i, row := range rows {
+		if err := json.Unmarshal(row, &records[i]); err != nil {
+			return nil, errors.Wrap(err, "failed to json.Unmarshal record")
+		}
+	}
+
+	return records, nil
+}
+
+// track creates the tracking tables if they don't exist
+func (s *Sqlite) track() error {
+	for _, stmt := range trackerStatements {
+		if _, err := s.db.Exec(stmt); err != nil {
+			return errors.Wrap(err, "failed to db.Exec")
+		}
+	}
+
+	return nil
+}
+
+// setPosition records seq as the position of the database, unless it's already past it,
+// as a transaction redriven from the dead letter queue is applied after later ones
+func setPosition(tx *sqlx.Tx, seq uint64) error {
+	if _, err := tx.Exec("UPDATE libsdk_position SET seq = max(seq, ?) WHERE id = 0", seq); err != nil {
+		return errors.Wrap(err, "failed to update position")
+	}
+
+	return nil
+}
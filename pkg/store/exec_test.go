@@ -0,0 +1,107 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cohix/libsdk/pkg/fabric"
+	fabricmem "github.com/cohix/libsdk/pkg/fabric/fabric-mem"
+	"github.com/cohix/libsdk/pkg/store"
+)
+
+// delayedReplayer holds back replayed messages while delaying is set, until release is closed
+type delayedReplayer struct {
+	*fabricmem.ReplayConnection
+	delaying *atomic.Bool
+	release  chan bool
+}
+
+// Replay replays messages to recv, holding them back while delaying is set
+func (d *delayedReplayer) Replay(ctx context.Context, from fabric.Position, gen fabric.Generator, recv fabric.ReplayReceiver) (chan bool, error) {
+	return d.ReplayConnection.Replay(ctx, from, gen, func(seq uint64, msg any) error {
+		if d.delaying.Load() {
+			<-d.release
+		}
+
+		return recv(seq, msg)
+	})
+}
+
+// untracked is a driver that doesn't implement Tracker
+type untracked struct {
+	store.Driver
+	store.Viewer
+}
+
+type ctxKey struct{}
+
+func TestExecContext(t *testing.T) {
+	bus := fabricmem.NewBus()
+
+	delaying := &atomic.Bool{}
+	release := make(chan bool)
+
+	r := startReplica(t, bus, func(r *replica) {
+		// a driver that doesn't track the transactions it's applied relies on the store not to apply them twice
+		r.driver = func(d store.Driver) store.Driver {
+			return untracked{Driver: d, Viewer: d.(store.Viewer)}
+		}
+
+		r.replayer = func(conn *fabricmem.ReplayConnection) fabric.ReplayConnection {
+			return &delayedReplayer{ReplayConnection: conn, delaying: delaying, release: release}
+		}
+
+		r.handlers["readContext"] = func(tx store.Tx, args ...any) (any, error) {
+			return tx.Context().Value(ctxKey{}), nil
+		}
+	})
+
+	value, err := r.ExecContext(context.WithValue(context.Background(), ctxKey{}, "rick"), "readContext")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value != "rick" {
+		t.Fatalf("handler read %v from its context, want rick", value)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := r.ExecContext(cancelled, appendEntry, "cancelled"); err == nil {
+		t.Fatal("Exec succeeded with a cancelled context")
+	}
+
+	if count := r.entries(t, "cancelled"); count != 0 {
+		t.Fatalf("replica has %d entries from a cancelled Exec, want 0", count)
+	}
+
+	// the caller gives up after the transaction has been applied and published, but before it's replayed
+	delaying.Store(true)
+
+	timeout, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	if _, err := r.ExecContext(timeout, appendEntry, "late"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Exec returned %v, want context.DeadlineExceeded", err)
+	}
+
+	delaying.Store(false)
+	close(release)
+
+	// the transaction after it is replayed after it, and it isn't applied again when it's replayed
+	if _, err := r.Exec(appendEntry, "after"); err != nil {
+		t.Fatal(err)
+	}
+
+	if count := r.entries(t, "late"); count != 1 {
+		t.Fatalf("replica has %d entries from the Exec it gave up on, want 1", count)
+	}
+
+	if applied := r.applied.Load(); applied != 2 {
+		t.Fatalf("replica applied %d transactions, want 2", applied)
+	}
+}
@@ -0,0 +1,240 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Migration is a named change to the database schema. Migrations are applied in order, once each,
+// and must not be edited once they've been applied, which is detected using their checksums.
+type Migration struct {
+	Name string `json:"name"`
+	SQL  string `json:"sql"`
+}
+
+// Checksum returns a checksum of the migration's SQL
+func (m Migration) Checksum() string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(m.SQL)))
+}
+
+// MigrationsFS loads migrations from the .sql files in dir, which are applied in order of their file
+// names and named after them without the extension, i.e. 0001_create_people.sql is 0001_create_people.
+// Prefixing file names with a zero-padded number keeps them in the order they were written.
+func MigrationsFS(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to ReadDir %s", dir)
+	}
+
+	migrations := []Migration{}
+
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+
+		sql, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to ReadFile %s", entry.Name())
+		}
+
+		m := Migration{
+			Name: strings.TrimSuffix(entry.Name(), ".sql"),
+			SQL:  string(sql),
+		}
+
+		migrations = append(migrations, m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Name < migrations[j].Name
+	})
+
+	return migrations, nil
+}
+
+// validateMigrations returns an error if any migration is unnamed or shares its name with another
+func validateMigrations(migrations []Migration) error {
+	names := map[string]bool{}
+
+	for i, m := range migrations {
+		if m.Name == "" {
+			return fmt.Errorf("migration %d has no name", i)
+		}
+
+		if names[m.Name] {
+			return fmt.Errorf("migration %s is not uniquely named", m.Name)
+		}
+
+		names[m.Name] = true
+	}
+
+	return nil
+}
+
+// ErrUnknownMigration is returned by Exec and View, and closes subscriptions, once a migration
+// this replica doesn't know has been replayed
+var ErrUnknownMigration = errors.New("stream contains a migration unknown to this replica")
+
+// migrationRecord returns the record that publishes a migration. Its UUID is derived from the
+// migration, so that replicas publishing the same migration at once are deduplicated by the fabric.
+func migrationRecord(m Migration, origin string) TxRecord {
+	rec := TxRecord{
+		UUID:      fmt.Sprintf("migration-%s-%s", m.Name, m.Checksum()[:16]),
+		Origin:    origin,
+		Time:      time.Now().UTC(),
+		Migration: &m,
+	}
+
+	return rec
+}
+
+// checkMigrations returns an error if the database has migrations applied that aren't the first of
+// the known migrations, and notes whether any are applied, before anything is replayed
+func (s *Store) checkMigrations() error {
+	applied, err := s.driver.MigrationsApplied()
+	if err != nil {
+		return errors.Wrap(err, "failed to driver.MigrationsApplied")
+	}
+
+	if applied > len(s.migrations) {
+		return fmt.Errorf("database has %d migrations applied, but only %d are known", applied, len(s.migrations))
+	}
+
+	// migrating to the migrations already applied checks that they're the same
+	if err := s.driver.Migrate(s.migrations[:applied]); err != nil {
+		return errors.Wrap(err, "failed to driver.Migrate")
+	}
+
+	s.migrated = applied > 0
+
+	return nil
+}
+
+// applyMigration applies a replayed migration, which must be the next known migration to apply or already
+// applied. A migration that isn't known or is out of order stops the replica, as the transactions after it
+// depend on it. The applyLock must be held.
+func (s *Store) applyMigration(seq uint64, rec *TxRecord) error {
+	err := s.migrate(*rec.Migration)
+	if err != nil {
+		s.log.Error("stopping replay at migration that can't be applied", "seq", seq, "name", rec.Migration.Name, "err", err.Error())
+		s.refuse(ErrUnknownMigration, err)
+	}
+
+	s.advance(seq)
+	s.complete(rec.UUID, nil, err)
+
+	return nil
+}
+
+// migrate applies the migration if it's the next known migration to apply
+func (s *Store) migrate(m Migration) error {
+	index := slices.IndexFunc(s.migrations, func(known Migration) bool {
+		return known.Name == m.Name
+	})
+
+	if index < 0 {
+		return fmt.Errorf("migration %s is not known, this replica is older than the stream", m.Name)
+	}
+
+	if s.migrations[index].Checksum() != m.Checksum() {
+		return fmt.Errorf("migration %s differs from the migration with the same name known to this replica", m.Name)
+	}
+
+	applied, err := s.driver.MigrationsApplied()
+	if err != nil {
+		return errors.Wrap(err, "failed to driver.MigrationsApplied")
+	}
+
+	if index < applied {
+		return nil
+	}
+
+	if index > applied {
+		return fmt.Errorf("migration %s is out of order, %d migrations are applied but it is migration %d", m.Name, applied, index+1)
+	}
+
+	if err := s.driver.Migrate(s.migrations[:index+1]); err != nil {
+		return errors.Wrapf(err, "failed to driver.Migrate %s", m.Name)
+	}
+
+	s.migrated = true
+
+	return nil
+}
+
+// migrateLegacy applies every known migration before the first transaction is replayed, if none have been
+// applied, as streams from before migrations were published contain transactions without migrations.
+// The applyLock must be held.
+func (s *Store) migrateLegacy() error {
+	if s.migrated {
+		return nil
+	}
+
+	s.log.Warn("replaying transactions published before any migration, applying all migrations first")
+
+	if err := s.driver.Migrate(s.migrations); err != nil {
+		return errors.Wrap(err, "failed to driver.Migrate")
+	}
+
+	s.migrated = true
+
+	return nil
+}
+
+// publishMigrations publishes the known migrations that haven't been applied, in order,
+// waiting for each to be replayed and applied before publishing the next
+func (s *Store) publishMigrations(ctx context.Context) error {
+	s.applyLock.Lock()
+	applied, err := s.driver.MigrationsApplied()
+	s.applyLock.Unlock()
+
+	if err != nil {
+		return errors.Wrap(err, "failed to driver.MigrationsApplied")
+	}
+
+	for _, m := range s.migrations[min(applied, len(s.migrations)):] {
+		s.log.Info("publishing migration", "name", m.Name)
+
+		if err := s.publishMigration(ctx, m); err != nil {
+			return errors.Wrapf(err, "failed to publishMigration %s", m.Name)
+		}
+	}
+
+	return nil
+}
+
+// publishMigration publishes a migration and waits for it to be applied
+func (s *Store) publishMigration(ctx context.Context, m Migration) error {
+	ctx, cancel := s.withTimeout(ctx, ExecOptions{})
+	defer cancel()
+
+	rec := migrationRecord(m, s.options.Instance)
+
+	results := make(chan execResult, 1)
+
+	s.results.Store(rec.UUID, results)
+	defer s.results.Delete(rec.UUID)
+
+	if err := s.replayer.Publish(ctx, rec); err != nil {
+		return errors.Wrap(err, "failed to replayer.Publish")
+	}
+
+	select {
+	case res := <-results:
+		return res.err
+	case err := <-s.refused:
+		return errors.Wrap(err, "refused to apply migration")
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "failed waiting for migration to be applied")
+	}
+}
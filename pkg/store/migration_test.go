@@ -0,0 +1,141 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	fabricmem "github.com/cohix/libsdk/pkg/fabric/fabric-mem"
+	"github.com/cohix/libsdk/pkg/store"
+)
+
+// withAges adds the second migration and a transaction that depends on it
+func withAges(r *replica) {
+	r.migrations = []store.Migration{createTables, addAges}
+	r.handlers["setAge"] = func(tx store.Tx, args ...any) (any, error) {
+		return tx.ReadWrite().Exec("UPDATE people SET age = $1 WHERE name = $2", args...)
+	}
+}
+
+func TestMigrationsAppliedInStreamOrder(t *testing.T) {
+	bus := fabricmem.NewBus()
+
+	a := startReplica(t, bus, nil)
+
+	if _, err := a.Exec(insertPerson, "rick"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the new migration is applied by every replica between the transactions before and after it
+	b := startReplica(t, bus, withAges)
+
+	if _, err := b.Exec("setAge", 70, "rick"); err != nil {
+		t.Fatal(err)
+	}
+
+	c := startReplica(t, bus, withAges)
+
+	age := 0
+	if err := c.Get(context.Background(), &age, "SELECT age FROM people WHERE name = 'rick'"); err != nil {
+		t.Fatal(err)
+	}
+
+	if age != 70 {
+		t.Fatalf("age is %d on a new replica, want 70", age)
+	}
+}
+
+func TestUnknownMigrationRefused(t *testing.T) {
+	ctx := context.Background()
+	bus := fabricmem.NewBus()
+
+	old := startReplica(t, bus, nil)
+
+	sub, err := old.Subscribe(store.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	startReplica(t, bus, withAges)
+
+	// the running replica stops replaying at the migration it doesn't know
+	eventually(t, func() bool {
+		_, err := old.Exec(insertPerson, "rick")
+		return errors.Is(err, store.ErrUnknownMigration)
+	}, "Exec to return ErrUnknownMigration")
+
+	count := 0
+	if err := old.Get(ctx, &count, "SELECT count(*) FROM people"); !errors.Is(err, store.ErrUnknownMigration) {
+		t.Fatalf("Get returned %v, want ErrUnknownMigration", err)
+	}
+
+	for range sub.Events() {
+	}
+
+	if !errors.Is(sub.Err(), store.ErrUnknownMigration) {
+		t.Fatalf("subscription closed with %v, want ErrUnknownMigration", sub.Err())
+	}
+
+	// and a replica started with the old migrations refuses to start
+	if err := newReplica(t, bus).start(t); err == nil {
+		t.Fatal("replica started without a migration in the stream")
+	}
+}
+
+func TestMigrationsFSDetectsEdits(t *testing.T) {
+	bus := fabricmem.NewBus()
+	dir := t.TempDir()
+
+	fsys := fstest.MapFS{
+		"migrations/0002_add_ages.sql":      {Data: []byte(addAges.SQL)},
+		"migrations/0001_create_tables.sql": {Data: []byte(createTables.SQL)},
+		"migrations/README.md":              {Data: []byte("not a migration")},
+	}
+
+	load := func() []store.Migration {
+		migrations, err := store.MigrationsFS(fsys, "migrations")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return migrations
+	}
+
+	migrations := load()
+
+	if !slices.Equal(migrations, []store.Migration{createTables, addAges}) {
+		t.Fatalf("MigrationsFS loaded %+v, want the .sql files in order of their names", migrations)
+	}
+
+	persistent := func(r *replica) {
+		r.driverOpts.Dir = dir
+		r.driverOpts.Persistent = true
+		r.migrations = migrations
+	}
+
+	a := startReplica(t, bus, persistent)
+
+	if err := a.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same migrations are already applied, so the replica restarts
+	a = startReplica(t, bus, persistent)
+
+	if err := a.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	// editing a migration after it's been applied would leave replicas with different schemas
+	fsys["migrations/0001_create_tables.sql"] = &fstest.MapFile{Data: []byte(createTables.SQL + "; CREATE INDEX people_name ON people (name)")}
+	migrations = load()
+
+	edited := newReplica(t, bus)
+	persistent(edited)
+
+	if err := edited.start(t); err == nil {
+		t.Fatal("replica started with an edited migration")
+	}
+}
@@ -0,0 +1,168 @@
+package store
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cohix/libsdk/pkg/fabric"
+	"github.com/pkg/errors"
+)
+
+// environment variables read by OptionsFromEnv
+const (
+	snapshotIntervalEnvKey = "LIBSDK_STORE_SNAPSHOT_INTERVAL" // a time.Duration string, i.e. 10m, 0 to disable
+	orderedEnvKey          = "LIBSDK_STORE_ORDERED"           // true to enable ordered mode
+	instanceEnvKey         = "LIBSDK_INSTANCE_ID"
+	regionEnvKey           = "LIBSDK_REGION"
+	debugEnvKey            = "LIBSDK_STORE_DEBUG"              // true to enable debug mode
+	execTimeoutEnvKey      = "LIBSDK_STORE_EXEC_TIMEOUT"       // a time.Duration string, i.e. 30s
+	checksumIntervalEnvKey = "LIBSDK_STORE_CHECKSUM_INTERVAL"  // a time.Duration string, i.e. 5m, 0 to disable
+	haltEnvKey             = "LIBSDK_STORE_HALT_ON_DIVERGENCE" // true to stop serving once divergence is detected
+	changeHistoryEnvKey    = "LIBSDK_STORE_CHANGE_HISTORY"
+)
+
+// Options configures a Store
+type Options struct {
+	// Snapshots holds snapshots of the store's database. When set and the driver is a
+	// Snapshotter, Start restores the latest snapshot and replays only the transactions
+	// after it. Without it, every transaction is replayed from the beginning.
+	Snapshots fabric.SnapshotStore
+
+	// SnapshotInterval is how often the replica stores a new snapshot, 0 to disable.
+	SnapshotInterval time.Duration
+
+	// ExecTimeout is the maximum time Exec waits for a transaction to be applied and replicated,
+	// unless the context passed to ExecContext has a sooner deadline. 0 leaves only the context's deadline.
+	ExecTimeout time.Duration
+
+	// Ordered applies every transaction in the order it was published, including on the replica that
+	// executed it. Exec publishes the transaction first and returns the result computed when this replica
+	// replays it, so concurrent writers on different replicas converge on the same state. Every Exec is
+	// published, so reads should use View. LocalOnly consistency is not supported in ordered mode.
+	Ordered bool
+
+	// Acks carries acknowledgements that replicas have applied transactions, which
+	// are required to Exec with ReplicaAcks consistency. Optional.
+	Acks fabric.BroadcastConnection
+
+	// Instance identifies this replica, and must be unique amongst the service's replicas. It defaults
+	// to the hostname with a random suffix, as several replicas may run on one host. Region is where it runs.
+	Instance string
+	Region   string
+
+	// Checksums carries checksums of the whole database between replicas, which are sent every
+	// ChecksumInterval and compared by replicas that have applied the same transactions. Optional,
+	// but the only way divergence is detected in ordered mode, where transactions carry no digest.
+	Checksums        fabric.BroadcastConnection
+	ChecksumInterval time.Duration
+
+	// HaltOnDivergence stops the store serving Exec and View, which return ErrDiverged, once this
+	// replica's changes or checksum are found to differ from another's. OnDivergence is called with
+	// each divergence detected, after it's logged, such as to record a metric. Optional.
+	HaltOnDivergence bool
+	OnDivergence     func(d Divergence)
+
+	// ChangeHistory is the number of the latest change events held for resuming subscriptions, 0 for none.
+	ChangeHistory int
+
+	// Debug executes every transaction a second time without committing it, when the driver is a
+	// DryRunner, and logs an error if the results differ, which flags handlers that would produce
+	// different data when replayed. This doubles the cost of every transaction.
+	Debug bool
+}
+
+// DefaultOptions returns Options that snapshot every 10 minutes once Snapshots is set, checksum
+// every 5 minutes once Checksums is set, time out Exec after 30s, and hold 1024 change events
+func DefaultOptions() Options {
+	// an unknown hostname leaves the instance identified by its suffix alone
+	hostname, _ := os.Hostname()
+
+	o := Options{
+		SnapshotInterval: time.Minute * 10,
+		ExecTimeout:      time.Second * 30,
+		ChecksumInterval: time.Minute * 5,
+		ChangeHistory:    1024,
+		Instance:         fmt.Sprintf("%s-%08x", hostname, rand.Uint32()),
+	}
+
+	return o
+}
+
+// OptionsFromEnv returns DefaultOptions overridden by any LIBSDK_STORE_* environment variables
+func OptionsFromEnv() (Options, error) {
+	o := DefaultOptions()
+
+	if interval, exists := os.LookupEnv(snapshotIntervalEnvKey); exists {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			return o, errors.Wrapf(err, "failed to parse %s", snapshotIntervalEnvKey)
+		}
+
+		o.SnapshotInterval = d
+	}
+
+	if timeout, exists := os.LookupEnv(execTimeoutEnvKey); exists {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return o, errors.Wrapf(err, "failed to parse %s", execTimeoutEnvKey)
+		}
+
+		o.ExecTimeout = d
+	}
+
+	if interval, exists := os.LookupEnv(checksumIntervalEnvKey); exists {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			return o, errors.Wrapf(err, "failed to parse %s", checksumIntervalEnvKey)
+		}
+
+		o.ChecksumInterval = d
+	}
+
+	if halt, exists := os.LookupEnv(haltEnvKey); exists {
+		h, err := strconv.ParseBool(halt)
+		if err != nil {
+			return o, errors.Wrapf(err, "failed to parse %s", haltEnvKey)
+		}
+
+		o.HaltOnDivergence = h
+	}
+
+	if history, exists := os.LookupEnv(changeHistoryEnvKey); exists {
+		h, err := strconv.Atoi(history)
+		if err != nil {
+			return o, errors.Wrapf(err, "failed to parse %s", changeHistoryEnvKey)
+		}
+
+		o.ChangeHistory = h
+	}
+
+	if ordered, exists := os.LookupEnv(orderedEnvKey); exists {
+		ord, err := strconv.ParseBool(ordered)
+		if err != nil {
+			return o, errors.Wrapf(err, "failed to parse %s", orderedEnvKey)
+		}
+
+		o.Ordered = ord
+	}
+
+	if instance, exists := os.LookupEnv(instanceEnvKey); exists {
+		o.Instance = instance
+	}
+
+	o.Region = os.Getenv(regionEnvKey)
+
+	if debug, exists := os.LookupEnv(debugEnvKey); exists {
+		d, err := strconv.ParseBool(debug)
+		if err != nil {
+			return o, errors.Wrapf(err, "failed to parse %s", debugEnvKey)
+		}
+
+		o.Debug = d
+	}
+
+	return o, nil
+}
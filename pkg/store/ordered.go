@@ -0,0 +1,77 @@
+package store
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// execResult is the outcome of applying a transaction in ordered mode
+type execResult struct {
+	result any
+	err    error
+}
+
+// execOrdered publishes the transaction and waits for this replica to apply it in stream order,
+// returning the handler's result or error from that point, and then for any acknowledgements
+func (s *Store) execOrdered(ctx context.Context, opts ExecOptions, rec TxRecord) (any, error) {
+	if opts.Consistency == LocalOnly {
+		return nil, errors.New("LocalOnly consistency is not supported in ordered mode")
+	}
+
+	results := make(chan execResult, 1)
+
+	s.results.Store(rec.UUID, results)
+	defer s.results.Delete(rec.UUID)
+
+	// refuse may have run before the transaction was stored, in which case it'll never be applied
+	if err := s.outdatedErr(); err != nil {
+		return nil, err
+	}
+
+	var waiter *ackWaiter
+
+	if rec.Ack {
+		waiter = newAckWaiter(opts, s.options.Region)
+
+		s.waiters.Store(rec.UUID, waiter)
+		defer s.waiters.Delete(rec.UUID)
+	}
+
+	if err := s.replayer.Publish(ctx, rec); err != nil {
+		return nil, errors.Wrapf(err, "failed to replayer.Publish for tx %s with name %s", rec.UUID, rec.Name)
+	}
+
+	var res execResult
+
+	select {
+	case res = <-results:
+	case <-ctx.Done():
+		return nil, errors.Wrapf(ctx.Err(), "failed waiting for transaction %s with name %s to be applied", rec.UUID, rec.Name)
+	}
+
+	if res.err != nil {
+		return nil, errors.Wrapf(res.err, "failed to Exec transaction %s with name %s", rec.UUID, rec.Name)
+	}
+
+	if waiter != nil {
+		if err := waiter.wait(ctx); err != nil {
+			return nil, errors.Wrapf(err, "failed to replicate transaction %s with name %s", rec.UUID, rec.Name)
+		}
+	}
+
+	return res.result, nil
+}
+
+// complete passes the outcome of applying a transaction to Exec, if it's waiting for it
+func (s *Store) complete(uuid string, result any, err error) {
+	results, exists := s.results.LoadAndDelete(uuid)
+	if !exists {
+		return
+	}
+
+	results.(chan execResult) <- execResult{
+		result: result,
+		err:    err,
+	}
+}
@@ -0,0 +1,52 @@
+package store_test
+
+import (
+	"slices"
+	"sync"
+	"testing"
+
+	fabricmem "github.com/cohix/libsdk/pkg/fabric/fabric-mem"
+)
+
+func TestOrderedConflictingWrites(t *testing.T) {
+	bus := fabricmem.NewBus()
+
+	ordered := func(r *replica) {
+		r.opts.Ordered = true
+	}
+
+	a := startReplica(t, bus, ordered)
+	b := startReplica(t, bus, ordered)
+
+	// both replicas insert the same unique names concurrently, so whichever is
+	// first in the stream succeeds and the other is rejected by every replica
+	names := []string{"beth", "jerry", "morty", "rick", "summer"}
+	errs := make([]error, len(names)*2)
+	wg := sync.WaitGroup{}
+
+	for i, name := range names {
+		for j, r := range []*replica{a, b} {
+			wg.Add(1)
+
+			go func(i int, r *replica, name string) {
+				defer wg.Done()
+				_, errs[i] = r.Exec(insertPerson, name)
+			}(i*2+j, r, name)
+		}
+	}
+
+	wg.Wait()
+
+	for i := range names {
+		if (errs[i*2] == nil) == (errs[i*2+1] == nil) {
+			t.Fatalf("inserting %s on both replicas returned errors %v and %v, want exactly one to fail", names[i], errs[i*2], errs[i*2+1])
+		}
+	}
+
+	// each replica replayed the insert that was rejected or succeeded for it, so both have every name
+	for _, r := range []*replica{a, b} {
+		if got := r.names(t); !slices.Equal(got, names) {
+			t.Fatalf("replica has %v, want %v", got, names)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cohix/libsdk/pkg/fabric"
+	"github.com/pkg/errors"
+)
+
+// the outbox is checked every outboxInterval for transactions that were executed locally more than
+// outboxRetryAfter ago and still haven't been replayed, which are published again
+const outboxInterval = time.Second * 10
+const outboxRetryAfter = time.Minute
+
+// outbox returns the driver's outbox if transactions should be republished from it. Republishing relies
+// on the fabric discarding transactions that were already published, so the fabric must remember their
+// UUIDs for longer than transactions are left in the outbox before they're republished.
+func (s *Store) outbox() (Outbox, bool, error) {
+	outbox, ok := s.driver.(Outbox)
+
+	// nothing is executed locally before publishing in ordered mode
+	if !ok || s.options.Ordered {
+		return nil, false, nil
+	}
+
+	dedup, ok := s.replayer.(fabric.Deduplicator)
+	if !ok {
+		s.log.Warn("fabric does not discard duplicate transactions, transactions that fail to publish will not be republished")
+		return nil, false, nil
+	}
+
+	if window := dedup.DuplicateWindow(); window <= outboxRetryAfter {
+		return nil, false, fmt.Errorf("fabric duplicate window %s must be longer than the outbox retry delay %s", window, outboxRetryAfter)
+	}
+
+	return outbox, true, nil
+}
+
+// outboxLoop publishes pending transactions from the outbox until ctx is cancelled. It first runs
+// once Replay has caught up, publishing transactions left behind by a previous run that failed.
+func (s *Store) outboxLoop(ctx context.Context, outbox Outbox) {
+	ticker := time.NewTicker(outboxInterval)
+	defer ticker.Stop()
+
+	before := time.Now()
+
+	for {
+		if err := s.retryPending(ctx, outbox, before); err != nil {
+			s.log.Error(errors.Wrap(err, "failed to retryPending").Error())
+		}
+
+		select {
+		case <-ticker.C:
+			before = time.Now().Add(-outboxRetryAfter)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// retryPending publishes the transactions in the outbox executed before the given time, other than those
+// that Exec is still waiting on. Transactions are identified to the fabric by UUID, so that a transaction
+// that was published but not yet replayed is discarded by the fabric as a duplicate. Once a transaction
+// is older than the fabric's duplicate window, it could be applied twice, so it is no longer republished.
+func (s *Store) retryPending(ctx context.Context, outbox Outbox, before time.Time) error {
+	pending, err := outbox.Pending(before)
+	if err != nil {
+		return errors.Wrap(err, "failed to Pending")
+	}
+
+	window := s.replayer.(fabric.Deduplicator).DuplicateWindow()
+
+	for _, rec := range pending {
+		if tx, exists := s.inflight.Load(rec.UUID); exists && tx.(*inflightTx).replayed != nil {
+			continue
+		}
+
+		if time.Since(rec.Time) >= window {
+			s.log.Error("transaction in outbox is older than the fabric's duplicate window, not republishing", "uuid", rec.UUID, "name", rec.Name, "time", rec.Time)
+			continue
+		}
+
+		s.log.Warn("publishing transaction from outbox", "uuid", rec.UUID, "name", rec.Name)
+
+		if err := s.replayer.Publish(ctx, rec); err != nil {
+			return errors.Wrapf(err, "failed to replayer.Publish for tx %s with name %s", rec.UUID, rec.Name)
+		}
+	}
+
+	return nil
+}
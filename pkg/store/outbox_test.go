@@ -0,0 +1,104 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cohix/libsdk/pkg/fabric"
+	fabricmem "github.com/cohix/libsdk/pkg/fabric/fabric-mem"
+	"github.com/cohix/libsdk/pkg/store"
+)
+
+// unreliableReplayer fails to publish while the fabric is down, and can report a different duplicate window
+type unreliableReplayer struct {
+	*fabricmem.ReplayConnection
+	down   *atomic.Bool
+	window time.Duration
+}
+
+// Publish publishes msg unless the fabric is down
+func (u *unreliableReplayer) Publish(ctx context.Context, msg any) error {
+	if u.down.Load() {
+		return errors.New("fabric is down")
+	}
+
+	return u.ReplayConnection.Publish(ctx, msg)
+}
+
+// DuplicateWindow returns the window set for the test, or the fabric's
+func (u *unreliableReplayer) DuplicateWindow() time.Duration {
+	if u.window > 0 {
+		return u.window
+	}
+
+	return u.ReplayConnection.DuplicateWindow()
+}
+
+func TestOutboxRepublishes(t *testing.T) {
+	ctx := context.Background()
+	bus := fabricmem.NewBus()
+	dir := t.TempDir()
+	down := &atomic.Bool{}
+
+	unreliable := func(r *replica) {
+		r.driverOpts.Dir = dir
+		r.driverOpts.Persistent = true
+		r.replayer = func(conn *fabricmem.ReplayConnection) fabric.ReplayConnection {
+			return &unreliableReplayer{ReplayConnection: conn, down: down}
+		}
+	}
+
+	a := startReplica(t, bus, unreliable)
+	b := startReplica(t, bus, nil)
+
+	down.Store(true)
+
+	opts := store.ExecOptions{
+		Consistency: store.LocalOnly,
+	}
+
+	if _, err := a.ExecWith(ctx, opts, appendEntry, "local"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	down.Store(false)
+
+	// the transaction is published from the outbox once the replica restarts
+	restarted := startReplica(t, bus, unreliable)
+
+	eventually(t, func() bool { return b.entries(t, "local") == 1 }, "b to apply the transaction from the outbox")
+
+	// publish again to be sure the replica has replayed the republished transaction
+	if _, err := restarted.Exec(appendEntry, "after"); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, func() bool { return b.entries(t, "after") == 1 }, "b to apply the transaction after it")
+
+	for _, r := range []*replica{restarted, b} {
+		if count := r.entries(t, "local"); count != 1 {
+			t.Fatalf("replica applied the republished transaction %d times, want 1", count)
+		}
+	}
+}
+
+func TestOutboxRequiresLongerDuplicateWindow(t *testing.T) {
+	bus := fabricmem.NewBus()
+
+	r := newReplica(t, bus)
+	r.driverOpts.Persistent = true
+	r.replayer = func(conn *fabricmem.ReplayConnection) fabric.ReplayConnection {
+		return &unreliableReplayer{ReplayConnection: conn, down: &atomic.Bool{}, window: time.Second * 30}
+	}
+
+	if err := r.start(t); err == nil {
+		t.Fatal("store started with a duplicate window shorter than the outbox retry delay")
+	}
+}
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cohix/libsdk/pkg/fabric"
+	_ "github.com/cohix/libsdk/pkg/fabric/codec-cbor"
+	_ "github.com/cohix/libsdk/pkg/fabric/codec-msgpack"
+)
+
+// every replica must decode the same record that the executing replica ran its handler with,
+// otherwise Tx.Now, Tx.Rand and Tx.NewUUID produce different values on different replicas
+func TestTxRecordCodecRoundTrip(t *testing.T) {
+	rec := TxRecord{
+		UUID:    "0190b1c2-0000-7000-8000-000000000000",
+		Name:    "InsertPerson",
+		Version: 2,
+		Args:    TxArgs{"rick", int64(70), time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.UTC)},
+		Origin:  "host-1",
+		Ack:     true,
+		Time:    time.Date(2024, 5, 6, 7, 8, 9, 115696813, time.UTC),
+		Seed:    8675309,
+		Digest:  "1:abc",
+		Batch: []BatchEntry{
+			{Name: "InsertRole", Version: 1, Args: TxArgs{"admin"}},
+		},
+		Migration: &Migration{Name: "0001", SQL: "CREATE TABLE people (id INTEGER)"},
+	}
+
+	for _, name := range []string{"json", "cbor", "msgpack"} {
+		t.Run(name, func(t *testing.T) {
+			codec, err := fabric.CodecByName(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := codec.Marshal(rec)
+			if err != nil {
+				t.Fatalf("failed to Marshal: %s", err)
+			}
+
+			decoded := TxRecord{}
+
+			if err := codec.Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("failed to Unmarshal: %s", err)
+			}
+
+			if !decoded.Time.Equal(rec.Time) {
+				t.Errorf("time %s, want %s", decoded.Time.Format(time.RFC3339Nano), rec.Time.Format(time.RFC3339Nano))
+			}
+
+			// the deterministic values handlers see must match the executing replica's
+			want, got := NewDeterministic(rec), NewDeterministic(decoded)
+
+			wantID, _ := want.NewUUID()
+			gotID, _ := got.NewUUID()
+
+			if wantID != gotID {
+				t.Errorf("NewUUID %s, want %s", gotID, wantID)
+			}
+
+			if want.Rand().Int63() != got.Rand().Int63() {
+				t.Error("Rand produced different values")
+			}
+
+			decoded.Time = rec.Time
+
+			if !reflect.DeepEqual(decoded, rec) {
+				t.Errorf("decoded %+v, want %+v", decoded, rec)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package store_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cohix/libsdk/pkg/fabric"
+	fabricmem "github.com/cohix/libsdk/pkg/fabric/fabric-mem"
+	"github.com/cohix/libsdk/pkg/store"
+	driversqlite "github.com/cohix/libsdk/pkg/store/driver-sqlite"
+	"github.com/pkg/errors"
+)
+
+const serviceName = "people"
+
+const (
+	insertPerson store.TxName = "insertPerson"
+	appendEntry  store.TxName = "appendEntry"
+)
+
+var createTables = store.Migration{
+	Name: "0001_create_tables",
+	SQL:  "CREATE TABLE people (id INTEGER PRIMARY KEY, name TEXT NOT NULL UNIQUE); CREATE TABLE entries (id INTEGER PRIMARY KEY, entry TEXT NOT NULL)",
+}
+
+var addAges = store.Migration{
+	Name: "0002_add_ages",
+	SQL:  "ALTER TABLE people ADD COLUMN age INTEGER NOT NULL DEFAULT 0",
+}
+
+// replica is a store under test, configured before it's started
+type replica struct {
+	*store.Store
+	fabric     *fabricmem.Mem
+	opts       store.Options
+	driverOpts driversqlite.Options
+	migrations []store.Migration
+	handlers   map[store.TxName]store.TxHandler
+	register   []func(s *store.Store) error // registers typed transactions with the replica's store
+	applied    atomic.Int64                 // the number of times a handler has run on the replica
+
+	// replayer wraps the replica's connection to the stream, and driver its driver, both optional
+	replayer func(r *fabricmem.ReplayConnection) fabric.ReplayConnection
+	driver   func(d store.Driver) store.Driver
+}
+
+// newReplica configures a replica of the people service with a non-persistent database
+func newReplica(t *testing.T, bus *fabricmem.Bus) *replica {
+	opts := store.DefaultOptions()
+	opts.ExecTimeout = time.Second * 5
+
+	r := &replica{
+		fabric:     fabricmem.NewWithBus(serviceName, bus),
+		opts:       opts,
+		driverOpts: driversqlite.Options{Dir: t.TempDir()},
+		migrations: []store.Migration{createTables},
+	}
+
+	r.handlers = map[store.TxName]store.TxHandler{
+		insertPerson: r.count(func(tx store.Tx, args ...any) (any, error) {
+			return tx.ReadWrite().Exec("INSERT INTO people (name) VALUES ($1)", args...)
+		}),
+		appendEntry: r.count(func(tx store.Tx, args ...any) (any, error) {
+			return tx.ReadWrite().Exec("INSERT INTO entries (entry) VALUES ($1)", args...)
+		}),
+	}
+
+	return r
+}
+
+// count wraps handler to count the times it runs
+func (r *replica) count(handler store.TxHandler) store.TxHandler {
+	return func(tx store.Tx, args ...any) (any, error) {
+		r.applied.Add(1)
+		return handler(tx, args...)
+	}
+}
+
+// start starts the replica, which is stopped when the test ends
+func (r *replica) start(t *testing.T) error {
+	conn, err := r.fabric.Replayer(context.Background(), "store")
+	if err != nil {
+		return errors.Wrap(err, "failed to Replayer")
+	}
+
+	replayer := conn
+	if r.replayer != nil {
+		replayer = r.replayer(conn.(*fabricmem.ReplayConnection))
+	}
+
+	driver, err := driversqlite.NewWithOptions(serviceName, r.driverOpts)
+	if err != nil {
+		return errors.Wrap(err, "failed to driversqlite.NewWithOptions")
+	}
+
+	var d store.Driver = driver
+	if r.driver != nil {
+		d = r.driver(driver)
+	}
+
+	r.Store = store.NewWithOptions(d, replayer, r.opts)
+
+	for name, handler := range r.handlers {
+		if err := r.Register(name, handler); err != nil {
+			return errors.Wrap(err, "failed to Register")
+		}
+	}
+
+	for _, register := range r.register {
+		if err := register(r.Store); err != nil {
+			return errors.Wrap(err, "failed to register")
+		}
+	}
+
+	s := r.Store
+
+	t.Cleanup(func() {
+		s.Stop()
+	})
+
+	if err := s.Start(r.migrations); err != nil {
+		return errors.Wrap(err, "failed to Start")
+	}
+
+	return nil
+}
+
+// startReplica configures and starts a replica, failing the test if it doesn't start
+func startReplica(t *testing.T, bus *fabricmem.Bus, configure func(r *replica)) *replica {
+	t.Helper()
+
+	r := newReplica(t, bus)
+
+	if configure != nil {
+		configure(r)
+	}
+
+	if err := r.start(t); err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+// names returns the names of the people in the replica's database
+func (r *replica) names(t *testing.T) []string {
+	t.Helper()
+
+	names := []string{}
+
+	if err := r.Select(context.Background(), &names, "SELECT name FROM people ORDER BY name"); err != nil {
+		t.Fatal(err)
+	}
+
+	return names
+}
+
+// entries returns the number of entries in the replica's database with the given value
+func (r *replica) entries(t *testing.T, entry string) int {
+	t.Helper()
+
+	count := 0
+
+	if err := r.Get(context.Background(), &count, "SELECT count(*) FROM entries WHERE entry = $1", entry); err != nil {
+		t.Fatal(err)
+	}
+
+	return count
+}
+
+// eventually fails the test if cond isn't true within a few seconds
+func eventually(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second * 5)
+
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting: %s", msg)
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+}
@@ -0,0 +1,165 @@
+package store
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/cohix/libsdk/pkg/fabric"
+	"github.com/pkg/errors"
+)
+
+// Snapshotter is implemented by drivers that can snapshot and restore their database
+type Snapshotter interface {
+	// Snapshot returns a reader of a consistent copy of the database, which must be closed.
+	// The copy must be complete by the time Snapshot returns, as writes resume afterwards.
+	Snapshot() (io.ReadCloser, error)
+
+	// Restore replaces the database with a snapshot's data
+	Restore(r io.Reader) error
+}
+
+// Snapshot stores a snapshot of the database covering every transaction applied so far,
+// unless the latest snapshot already covers them. New transactions are blocked while the
+// database is being copied, but not while the copy is stored.
+func (s *Store) Snapshot(ctx context.Context) error {
+	snapshotter, ok := s.driver.(Snapshotter)
+	if !ok {
+		return errors.New("store driver does not support snapshots")
+	}
+
+	if s.options.Snapshots == nil {
+		return errors.New("store has no snapshot store configured")
+	}
+
+	latest, err := s.options.Snapshots.Latest(ctx)
+	if err != nil && !errors.Is(err, fabric.ErrNoSnapshot) {
+		return errors.Wrap(err, "failed to Snapshots.Latest")
+	}
+
+	seq, data, err := s.copyDatabase(snapshotter, latest)
+	if err != nil {
+		return errors.Wrap(err, "failed to copyDatabase")
+	}
+
+	if data == nil {
+		return nil
+	}
+
+	defer data.Close()
+
+	// a replica that's further along may have stored a snapshot while the database was being copied
+	latest, err = s.options.Snapshots.Latest(ctx)
+	if err != nil && !errors.Is(err, fabric.ErrNoSnapshot) {
+		return errors.Wrap(err, "failed to Snapshots.Latest")
+	}
+
+	if latest != nil && latest.Sequence >= seq {
+		s.log.Info("skipping snapshot, a newer one was stored", "seq", seq, "latest", latest.Sequence)
+		return nil
+	}
+
+	if err := s.options.Snapshots.Put(ctx, seq, data); err != nil {
+		return errors.Wrap(err, "failed to Snapshots.Put")
+	}
+
+	s.log.Info("stored snapshot", "seq", seq)
+
+	return nil
+}
+
+// copyDatabase snapshots the database along with the sequence it covers, or
+// returns a nil reader if nothing has been applied since the latest snapshot
+func (s *Store) copyDatabase(snapshotter Snapshotter, latest *fabric.Snapshot) (uint64, io.ReadCloser, error) {
+	s.execLock.Lock()
+	defer s.execLock.Unlock()
+
+	s.applyLock.Lock()
+	defer s.applyLock.Unlock()
+
+	if s.lastSeq == 0 || (latest != nil && latest.Sequence >= s.lastSeq) {
+		return 0, nil, nil
+	}
+
+	data, err := snapshotter.Snapshot()
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "failed to driver Snapshot")
+	}
+
+	return s.lastSeq, data, nil
+}
+
+// resume returns the position to replay from, either after the last transaction
+// applied by a Tracker driver, or after the latest snapshot once it's been restored
+func (s *Store) resume(ctx context.Context) (fabric.Position, error) {
+	if tracker, ok := s.driver.(Tracker); ok {
+		seq, err := tracker.Position()
+		if err != nil {
+			return fabric.FromBeginning, errors.Wrap(err, "failed to driver Position")
+		}
+
+		if seq > 0 {
+			s.lastSeq = seq
+			s.log.Info("resuming from last applied transaction", "seq", seq)
+
+			return fabric.AfterSequence(seq), nil
+		}
+	}
+
+	from, err := s.restore(ctx)
+	if err != nil {
+		return fabric.FromBeginning, errors.Wrap(err, "failed to restore")
+	}
+
+	return from, nil
+}
+
+// restore restores the latest snapshot, if there is one, and returns the position to replay from.
+// If the snapshot can't be fetched, replay starts from the beginning instead, but a failure to
+// restore it is returned, as the driver's database may not be usable.
+func (s *Store) restore(ctx context.Context) (fabric.Position, error) {
+	snapshotter, ok := s.driver.(Snapshotter)
+	if !ok || s.options.Snapshots == nil {
+		return fabric.FromBeginning, nil
+	}
+
+	snap, data, err := s.options.Snapshots.Open(ctx)
+	if err != nil {
+		if errors.Is(err, fabric.ErrNoSnapshot) {
+			return fabric.FromBeginning, nil
+		}
+
+		s.log.Warn("failed to open snapshot, replaying from the beginning", "err", err.Error())
+
+		return fabric.FromBeginning, nil
+	}
+
+	defer data.Close()
+
+	if err := snapshotter.Restore(data); err != nil {
+		return fabric.FromBeginning, errors.Wrap(err, "failed to driver Restore")
+	}
+
+	s.lastSeq = snap.Sequence
+
+	s.log.Info("restored snapshot", "seq", snap.Sequence, "time", snap.Time)
+
+	return fabric.AfterSequence(snap.Sequence), nil
+}
+
+// snapshotLoop stores a snapshot every SnapshotInterval until ctx is cancelled
+func (s *Store) snapshotLoop(ctx context.Context) {
+	ticker := time.NewTicker(s.options.SnapshotInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Snapshot(ctx); err != nil {
+				s.log.Error(errors.Wrap(err, "failed to Snapshot").Error())
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
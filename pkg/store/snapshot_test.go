@@ -0,0 +1,125 @@
+package store_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	fabricmem "github.com/cohix/libsdk/pkg/fabric/fabric-mem"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	ctx := context.Background()
+	bus := fabricmem.NewBus()
+
+	withSnapshots := func(r *replica) {
+		snapshots, err := r.fabric.Snapshots(ctx, "store")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.opts.Snapshots = snapshots
+		r.opts.SnapshotInterval = 0
+	}
+
+	a := startReplica(t, bus, withSnapshots)
+
+	for _, name := range []string{"beth", "morty", "rick"} {
+		if _, err := a.Exec(insertPerson, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := a.Snapshot(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	latest, err := a.opts.Snapshots.Latest(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.Exec(insertPerson, "summer"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new replica restores the snapshot and only replays the transaction after it
+	b := startReplica(t, bus, withSnapshots)
+
+	if names := b.names(t); !slices.Equal(names, []string{"beth", "morty", "rick", "summer"}) {
+		t.Fatalf("b has %v after restoring, want [beth morty rick summer]", names)
+	}
+
+	if applied := b.applied.Load(); applied != 1 {
+		t.Fatalf("b applied %d transactions after restoring, want 1", applied)
+	}
+
+	if err := b.Snapshot(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	newer, err := a.opts.Snapshots.Latest(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if newer.Sequence <= latest.Sequence {
+		t.Fatalf("snapshot sequence %d after b's snapshot, want more than %d", newer.Sequence, latest.Sequence)
+	}
+
+	// replicas that haven't applied anything newer don't replace the snapshot
+	if err := a.Snapshot(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Snapshot(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	unchanged, err := a.opts.Snapshots.Latest(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if unchanged.Sequence != newer.Sequence || !unchanged.Time.Equal(newer.Time) {
+		t.Fatalf("snapshot %+v was replaced by one covering the same transactions, want %+v", unchanged, newer)
+	}
+}
+
+func TestPersistentResume(t *testing.T) {
+	bus := fabricmem.NewBus()
+	dir := t.TempDir()
+
+	persistent := func(r *replica) {
+		r.driverOpts.Dir = dir
+		r.driverOpts.Persistent = true
+	}
+
+	a := startReplica(t, bus, persistent)
+	b := startReplica(t, bus, nil)
+
+	for _, name := range []string{"beth", "morty"} {
+		if _, err := a.Exec(insertPerson, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := a.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Exec(insertPerson, "rick"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the restarted replica only replays the transaction it missed
+	restarted := startReplica(t, bus, persistent)
+
+	if names := restarted.names(t); !slices.Equal(names, []string{"beth", "morty", "rick"}) {
+		t.Fatalf("restarted replica has %v, want [beth morty rick]", names)
+	}
+
+	if applied := restarted.applied.Load(); applied != 1 {
+		t.Fatalf("restarted replica applied %d transactions, want 1", applied)
+	}
+}
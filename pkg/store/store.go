@@ -2,9 +2,11 @@ package store
 
 import (
 	"context"
-	"fmt"
+	"io"
 	"log/slog"
+	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cohix/libsdk/pkg/fabric"
@@ -16,23 +18,103 @@ import (
 type Store struct {
 	driver       Driver
 	replayer     fabric.ReplayConnection
+	options      Options
 	log          *slog.Logger
-	transactions map[TxName]TxHandler
-	inflight     sync.Map
+	transactions map[TxName]map[int]TxHandler // handlers for each version of each transaction
+	inflight     sync.Map                     // local transactions that have been applied and are waiting to be replayed
+	waiters      sync.Map
+	results      sync.Map
+	cancel       context.CancelFunc
+
+	// execLock is held for reading by Exec until its transaction has been replayed, and
+	// applyLock while a replayed transaction is applied and lastSeq is updated, so that
+	// taking both exclusively gives a database state that matches lastSeq exactly
+	execLock  sync.RWMutex
+	applyLock sync.Mutex
+	lastSeq   uint64
+
+	// diverged is set once divergence is detected with HaltOnDivergence enabled, and
+	// checksums holds this replica's latest database checksum and each peer's
+	diverged      atomic.Bool
+	checksumLock  sync.Mutex
+	checksum      dbChecksum
+	peerChecksums map[string]dbChecksum
+
+	// migrations are those known to this replica, and migrated is set once the database has any applied.
+	// outdated is set if replay stopped at a migration or transaction this replica doesn't know, see refuse.
+	migrations []Migration
+	migrated   bool
+	outdated   atomic.Pointer[error]
+	refused    chan error
+
+	// subscribers receive change events, and history holds the latest events for resuming subscriptions,
+	// which is complete after historyFrom. pending holds the changes of local transactions until replayed.
+	feedLock    sync.Mutex
+	subscribers map[*Subscription]bool
+	history     []ChangeEvent
+	historyFrom uint64
+	pending     sync.Map
 }
 
 // Driver represents an underlying storage driver
 type Driver interface {
-	Exec(record TxRecord, handler TxHandler) (tx Tx, result any, err error)
-	Migrate(statements []string) error
+	Exec(ctx context.Context, record TxRecord, handler TxHandler) (tx Tx, result any, err error)
+	// Migrate applies the migrations that haven't been applied yet, in order, returning an error
+	// without applying any if one fails, or if those already applied differ from the migrations
+	Migrate(migrations []Migration) error
+
+	// MigrationsApplied returns the number of migrations applied to the database
+	MigrationsApplied() (int, error)
+}
+
+// Tracker is implemented by drivers that record which transactions have been applied in the
+// same database transaction that applies them, allowing the store to resume replaying from
+// the last transaction applied rather than from the beginning.
+type Tracker interface {
+	// Position returns the sequence of the last replayed transaction applied, 0 if there is none
+	Position() (uint64, error)
+
+	// ExecAt executes a transaction like Exec, recording seq as the position for a replayed
+	// transaction, or for a local transaction (seq 0) that writes, recording its UUID as applied
+	ExecAt(ctx context.Context, seq uint64, record TxRecord, handler TxHandler) (tx Tx, result any, err error)
+
+	// MarkApplied returns true if the transaction with the given UUID was executed locally,
+	// in which case it also records seq as the position
+	MarkApplied(seq uint64, uuid string) (bool, error)
+}
+
+// Outbox is implemented by Tracker drivers that keep each transaction executed locally in an outbox
+// until it has been replayed, so that the store can publish it again if publishing failed
+type Outbox interface {
+	// Pending returns the transactions in the outbox that were executed before the given time
+	Pending(before time.Time) ([]TxRecord, error)
+}
+
+// DryRunner is implemented by drivers that can execute a transaction without committing it
+type DryRunner interface {
+	// DryRun executes the transaction and returns its results, always rolling it back
+	DryRun(ctx context.Context, record TxRecord, handler TxHandler) (result any, err error)
+}
+
+// Viewer is implemented by drivers that can run read-only transactions outside of Exec
+type Viewer interface {
+	View(ctx context.Context, fn func(tx ReadTx) error) error
 }
 
 // Tx is an object that can itself kick off a read-only transaction
-// or a read-write transaction which is managed by the underlying driver
+// or a read-write transaction which is managed by the underlying driver.
+// Handlers must use Now, Rand and NewUUID rather than time.Now, math/rand
+// or uuid.NewV7, so that every replica replays the transaction identically.
+// Context is the caller's context when executed by Exec, or the store's
+// context when replayed, and the transaction is rolled back once it's done.
 type Tx interface {
+	Context() context.Context
 	Read() ReadTx
 	ReadWrite() ReadWriteTx
 	DidWrite() bool
+	Now() time.Time
+	Rand() *rand.Rand
+	NewUUID() (uuid.UUID, error)
 }
 
 // ReadTx is a read-only transaction
@@ -55,95 +137,388 @@ type TxName string
 
 // TxRecord is a serializable transaction for replication purposes
 type TxRecord struct {
-	UUID string `json:"uuid"`
-	Name TxName `json:"name"`
-	Args []any  `json:"args"`
+	UUID    string    `json:"uuid"`
+	Name    TxName    `json:"name"`
+	Version int       `json:"version,omitempty"` // the version of the handler that executed the transaction
+	Args    TxArgs    `json:"args"`
+	Origin  string    `json:"origin,omitempty"` // the instance that executed the transaction
+	Ack     bool      `json:"ack,omitempty"`    // replicas acknowledge applying the transaction
+	Time    time.Time `json:"time"`             // when the transaction was executed, see Tx.Now
+	Seed    int64     `json:"seed"`             // seeds the transaction's randomness, see Tx.Rand
+	Digest  string    `json:"digest,omitempty"` // summarises the rows changed by the transaction, see Digester
+
+	// Batch is set for records that execute several transactions atomically, rather than one named transaction
+	Batch []BatchEntry `json:"batch,omitempty"`
+
+	// Migration is set for records that migrate the schema, rather than execute a transaction
+	Migration *Migration `json:"migration,omitempty"`
+}
+
+// MessageID identifies the record to the fabric, so that publishing it again is idempotent
+func (t TxRecord) MessageID() string {
+	return t.UUID
 }
 
 // New creates a new Store with the given driver
 func New(driver Driver, replayer fabric.ReplayConnection) *Store {
+	return NewWithOptions(driver, replayer, DefaultOptions())
+}
+
+// NewWithOptions creates a new Store with the given driver, configured by opts
+func NewWithOptions(driver Driver, replayer fabric.ReplayConnection, opts Options) *Store {
 	s := &Store{
-		driver:       driver,
-		replayer:     replayer,
-		log:          slog.With("lib", "libsdk", "pkg", "store"),
-		transactions: map[TxName]TxHandler{},
-		inflight:     sync.Map{},
+		driver:        driver,
+		replayer:      replayer,
+		options:       opts,
+		log:           slog.With("lib", "libsdk", "pkg", "store"),
+		transactions:  map[TxName]map[int]TxHandler{},
+		inflight:      sync.Map{},
+		peerChecksums: map[string]dbChecksum{},
+		refused:       make(chan error, 1),
+		subscribers:   map[*Subscription]bool{},
+	}
+
+	if _, ok := driver.(DryRunner); opts.Debug && !ok {
+		s.options.Debug = false
+		s.log.Warn("store driver does not support dry runs, debug mode disabled")
 	}
 
 	return s
 }
 
-// Start starts the store replay loop
-func (s *Store) Start(migrations []string) error {
-	if err := s.driver.Migrate(migrations); err != nil {
-		return errors.Wrap(err, "failed to driver.Migrate")
+// Start starts the store replay loop, which runs until Stop is called. If the driver is a
+// Tracker that has already applied transactions, replay resumes after the last one. Otherwise,
+// if a snapshot is available, it is restored first and only the transactions after it are replayed.
+// Migrations are published to the stream once replay has caught up, so that each is applied by
+// every replica between the transactions that preceded and followed it. Start returns an error
+// if the stream contains a migration that isn't one of migrations.
+func (s *Store) Start(migrations []Migration) error {
+	if err := validateMigrations(migrations); err != nil {
+		return errors.Wrap(err, "invalid migrations")
 	}
 
+	s.migrations = migrations
+
+	// Replay will continue async even after the upToDate channel
+	// fires, but once it does, it is safe to continue as the db is
+	// up to date and ready for new queries etc.
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	from, err := s.resume(ctx)
+	if err != nil {
+		cancel()
+		return errors.Wrap(err, "failed to resume")
+	}
+
+	if err := s.checkMigrations(); err != nil {
+		cancel()
+		return errors.Wrap(err, "failed to checkMigrations")
+	}
+
+	// events for the transactions applied before starting aren't available to subscribers
+	s.historyFrom = s.lastSeq
+
 	msgGenerator := func() any {
 		return &TxRecord{}
 	}
 
-	msgHandler := func(msg any) {
+	// errors returned cause the record to be redelivered and eventually
+	// dead lettered, where it can be inspected with DeadLetters and re-driven
+	msgHandler := func(seq uint64, msg any) error {
 		txRec := msg.(*TxRecord)
 
-		s.log.Debug("replaying transaction", "uuid", txRec.UUID)
+		s.log.Debug("replaying transaction", "uuid", txRec.UUID, "seq", seq)
+
+		s.applyLock.Lock()
+		defer s.applyLock.Unlock()
+
+		if txRec.Migration != nil {
+			return s.applyMigration(seq, txRec)
+		}
+
+		if err := s.migrateLegacy(); err != nil {
+			return errors.Wrap(err, "failed to migrateLegacy")
+		}
+
+		handler, err := s.recordHandler(txRec)
+		if err != nil {
+			// a newer replica executed a transaction this one can't, rather than one that failed, so
+			// it isn't dead lettered, as every replica that can apply it has applied the records after it
+			s.log.Error("stopping replay at transaction that has no handler", "seq", seq, "uuid", txRec.UUID, "name", txRec.Name, "version", txRec.Version, "err", err.Error())
+			s.refuse(ErrUnknownTransaction, errors.Wrapf(err, "failed to recordHandler for transaction %s", txRec.UUID))
+			s.complete(txRec.UUID, nil, err)
+
+			return nil
+		}
+
+		applied := false
+
+		// a tracked transaction may have been executed locally before a restart
+		if tracker, ok := s.driver.(Tracker); ok {
+			a, err := tracker.MarkApplied(seq, txRec.UUID)
+			if err != nil {
+				return errors.Wrapf(err, "failed to MarkApplied for transaction %s", txRec.UUID)
+			}
 
-		handler, exists := s.transactions[txRec.Name]
-		if !exists {
-			s.log.Error(fmt.Sprintf("named transaction %s is not registered", txRec.Name))
-			return
+			applied = a
 		}
 
 		completion, exists := s.inflight.LoadAndDelete(txRec.UUID)
 		// if this is a new, in-flight transaction, it's already been executed,
 		// so we call its completion func to let the caller know it's done and exit
-		if exists {
-			cmplFunc := completion.(context.CancelFunc)
-			cmplFunc()
-			return
+		if exists && completion.(*inflightTx).replayed != nil {
+			completion.(*inflightTx).replayed(nil)
 		}
 
-		_, _, err := s.driver.Exec(*txRec, handler)
+		if exists || applied {
+			s.advance(seq)
+
+			// the changes were captured when the transaction was executed, unless that was before a restart
+			localChanges, _ := s.pending.LoadAndDelete(txRec.UUID)
+			rows, _ := localChanges.([]RowChange)
+			s.emit(seq, txRec, rows)
+
+			return nil
+		}
+
+		var handlerErr error
+
+		tx, result, err := s.exec(ctx, seq, *txRec, func(tx Tx, args ...any) (any, error) {
+			r, err := handler(tx, args...)
+			handlerErr = err
+
+			return r, err
+		})
+
 		if err != nil {
-			s.log.Error(errors.Wrapf(err, "failed to Exec replayed transaction %s with name %s", txRec.UUID, txRec.Name).Error())
+			// in ordered mode, a transaction rejected by its handler is rejected by every
+			// replica, so rejection is its outcome rather than a failure to apply it
+			if s.options.Ordered && handlerErr != nil {
+				s.advance(seq)
+				s.complete(txRec.UUID, nil, err)
+				return nil
+			}
+
+			return errors.Wrapf(err, "failed to Exec replayed transaction %s with name %s", txRec.UUID, txRec.Name)
+		}
+
+		s.advance(seq)
+		s.complete(txRec.UUID, result, nil)
+		s.verifyDigest(seq, txRec, tx)
+		s.emit(seq, txRec, changes(tx))
+
+		if txRec.Ack && txRec.Origin != s.options.Instance && s.options.Acks != nil {
+			s.acknowledge(ctx, txRec)
 		}
+
+		return nil
 	}
 
-	// Replay will continue async even after the upToDate channel
-	// fires, but once it does, it is safe to continue as the db is
-	// up to date and ready for new queries etc.
-	upToDate, err := s.replayer.Replay(msgGenerator, msgHandler)
+	if s.options.Acks != nil {
+		if err := s.receiveAcks(ctx); err != nil {
+			cancel()
+			return errors.Wrap(err, "failed to receiveAcks")
+		}
+	}
+
+	outbox, useOutbox, err := s.outbox()
 	if err != nil {
-		s.log.Error(errors.Wrap(err, "failed to replayer.Replay").Error())
+		cancel()
+		return errors.Wrap(err, "failed to outbox")
 	}
 
-	<-upToDate
+	upToDate, err := s.replayer.Replay(ctx, from, msgGenerator, msgHandler)
+	if err != nil {
+		cancel()
+		return errors.Wrap(err, "failed to replayer.Replay")
+	}
+
+	select {
+	case <-upToDate:
+	case err := <-s.refused:
+		cancel()
+		return errors.Wrap(err, "refused to start")
+	}
+
+	if err := s.publishMigrations(ctx); err != nil {
+		cancel()
+		return errors.Wrap(err, "failed to publishMigrations")
+	}
+
+	if _, ok := s.driver.(Snapshotter); ok && s.options.Snapshots != nil && s.options.SnapshotInterval > 0 {
+		go s.snapshotLoop(ctx)
+	}
+
+	if _, ok := s.driver.(Checksummer); ok && s.options.Checksums != nil {
+		if err := s.receiveChecksums(ctx); err != nil {
+			cancel()
+			return errors.Wrap(err, "failed to receiveChecksums")
+		}
+
+		if s.options.ChecksumInterval > 0 {
+			go s.checksumLoop(ctx)
+		}
+	}
+
+	if useOutbox {
+		go s.outboxLoop(ctx, outbox)
+	}
 
 	return nil
 }
 
-// Register registers the given transaction under the given name.
-// name must be unique, attempt to re-register with same name results in an error.
-func (s *Store) Register(name TxName, handler TxHandler) error {
-	_, exists := s.transactions[name]
-	if exists {
-		return fmt.Errorf("transaction registered with name %s already exists", name)
+// advance records seq as the last applied sequence. A transaction that's retried or redriven from
+// the dead letter queue is applied after later ones, so the position never moves backwards.
+// The applyLock must be held.
+func (s *Store) advance(seq uint64) {
+	s.lastSeq = max(s.lastSeq, seq)
+}
+
+// refuse stops replay at a record this replica is too old to apply, as the records after it depend on it.
+// Start returns err if it's still waiting for replay to catch up, and from then on Exec and View return
+// reason, and subscriptions are closed with it. The applyLock must be held.
+func (s *Store) refuse(reason error, err error) {
+	s.outdated.Store(&reason)
+
+	select {
+	case s.refused <- err:
+	default:
+	}
+
+	// transactions waiting to be replayed never will be
+	s.inflight.Range(func(uuid, completion any) bool {
+		s.inflight.Delete(uuid)
+
+		if replayed := completion.(*inflightTx).replayed; replayed != nil {
+			replayed(reason)
+		}
+
+		return true
+	})
+
+	s.results.Range(func(uuid, _ any) bool {
+		s.complete(uuid.(string), nil, reason)
+		return true
+	})
+
+	s.closeSubscriptions(reason)
+	s.cancel()
+}
+
+// outdatedErr returns the reason replay stopped, if it stopped at a record this replica is too old to apply
+func (s *Store) outdatedErr() error {
+	if reason := s.outdated.Load(); reason != nil {
+		return *reason
+	}
+
+	return nil
+}
+
+// Stop stops the store replay loop and closes its connection to the fabric
+func (s *Store) Stop() error {
+	if s.cancel != nil {
+		s.cancel()
 	}
 
-	s.transactions[name] = handler
+	s.closeSubscriptions(ErrStoreStopped)
+
+	if err := s.replayer.Close(); err != nil {
+		return errors.Wrap(err, "failed to replayer.Close")
+	}
+
+	if closer, ok := s.driver.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			return errors.Wrap(err, "failed to driver Close")
+		}
+	}
 
 	return nil
 }
 
+// exec executes the transaction with the driver, tracking it if the driver is a Tracker.
+// seq is the transaction's sequence when replayed, or 0 when executed locally.
+func (s *Store) exec(ctx context.Context, seq uint64, rec TxRecord, handler TxHandler) (Tx, any, error) {
+	if s.options.Debug {
+		return s.execDebug(ctx, seq, rec, handler)
+	}
+
+	if tracker, ok := s.driver.(Tracker); ok {
+		return tracker.ExecAt(ctx, seq, rec, handler)
+	}
+
+	return s.driver.Exec(ctx, rec, handler)
+}
+
+// Register registers the given transaction under the given name, which can include a version,
+// i.e. InsertPerson@v2, and is otherwise version 1. Transactions are executed with the latest
+// version registered, and replayed with the version that executed them, so a handler whose
+// behaviour changes should be registered as a new version, keeping the old versions registered.
+// A replica that replays a version it doesn't have stops replaying, see ErrUnknownTransaction.
+// name and version must be unique, attempt to re-register with same name results in an error.
+func (s *Store) Register(name TxName, handler TxHandler) error {
+	return s.register(name, handler)
+}
+
 // Exec performs a two-stage distributed transaction based on a
 // registered named TxHandler. The Tx is distributed using the fabric
 // and, upon confirmation of successful distribution, applied to the
 // local store replica. The result or error of the TxHandler is returned.
 // Non-errored call to Exec guarantees that replication succeeded.
 func (s *Store) Exec(name TxName, args ...any) (any, error) {
-	handler, exists := s.transactions[name]
-	if !exists {
-		return nil, fmt.Errorf("transaction with name %s is not registered", name)
+	return s.ExecContext(context.Background(), name, args...)
+}
+
+// ExecContext performs a transaction like Exec, giving up once ctx is cancelled or its deadline,
+// or the store's ExecTimeout, passes. The transaction's handler can read ctx with Tx.Context.
+// A transaction that was applied locally before the caller gave up may still be replicated.
+func (s *Store) ExecContext(ctx context.Context, name TxName, args ...any) (any, error) {
+	return s.ExecWith(ctx, ExecOptions{}, name, args...)
+}
+
+// ExecWith performs a transaction like ExecContext, replicating it with the consistency described by opts.
+// The transaction is always applied to this replica before ExecWith returns. If publishing fails after
+// it's applied and the driver is an Outbox, the transaction is published again in the background. In ordered mode,
+// the transaction is published before it's applied, see Options.Ordered.
+func (s *Store) ExecWith(ctx context.Context, opts ExecOptions, name TxName, args ...any) (any, error) {
+	base, version, err := parseTxName(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parseTxName")
+	}
+
+	handler, version, err := s.handler(base, version, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to handler")
+	}
+
+	// the handler receives the args as they are decoded after replication, so
+	// that it sees the same values here as it will on every other replica
+	txArgs, err := TxArgs(args).roundTrip()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to encode args for transaction with name %s", name)
+	}
+
+	txRec := TxRecord{
+		Name:    base,
+		Version: version,
+		Args:    txArgs,
+	}
+
+	return s.execute(ctx, opts, txRec, handler)
+}
+
+// execute executes the transaction described by txRec with the handler, and replicates it
+func (s *Store) execute(ctx context.Context, opts ExecOptions, txRec TxRecord, handler TxHandler) (any, error) {
+	if opts.Consistency == ReplicaAcks && s.options.Acks == nil {
+		return nil, errors.New("waiting for replica acknowledgements requires the store's Acks option")
+	}
+
+	if s.diverged.Load() {
+		return nil, ErrDiverged
+	}
+
+	if err := s.outdatedErr(); err != nil {
+		return nil, err
 	}
 
 	txUUID, err := uuid.NewV7()
@@ -151,42 +526,131 @@ func (s *Store) Exec(name TxName, args ...any) (any, error) {
 		return nil, errors.Wrap(err, "failed to uuid.NewV7")
 	}
 
-	txRec := TxRecord{
-		UUID: txUUID.String(),
-		Name: name,
-		Args: args,
+	txRec.UUID = txUUID.String()
+	txRec.Origin = s.options.Instance
+	txRec.Ack = opts.Consistency == ReplicaAcks
+	txRec.Time = time.Now().UTC()
+	txRec.Seed = rand.Int63()
+
+	ctx, cancel := s.withTimeout(ctx, opts)
+	defer cancel()
+
+	if s.options.Ordered {
+		return s.execOrdered(ctx, opts, txRec)
 	}
 
+	// the transaction is applied locally before it is replayed, so snapshots
+	// must wait until it has been replayed for lastSeq to account for it.
+	// publish releases the lock once the transaction has been replayed.
+	s.execLock.RLock()
+
 	// by this point, the driver has already either committed
 	// or rolled back the transaction internally, but it's
 	// returned so that we can determine if it should be distributed
-	tx, result, err := s.driver.Exec(txRec, handler)
+	tx, result, err := s.exec(ctx, 0, txRec, handler)
 	if err != nil {
+		s.execLock.RUnlock()
 		return nil, errors.Wrapf(err, "failed to Exec transaction %s with name %s", txRec.UUID, txRec.Name)
 	}
 
 	// if the transaction did not write, there is no
 	// reason to distribute it, so return its result early
 	if tx != nil && !tx.DidWrite() {
+		s.execLock.RUnlock()
 		return result, err
 	}
 
-	pubCtx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Second*30))
+	if digester, ok := tx.(Digester); ok {
+		txRec.Digest = digester.Digest()
+	}
+
+	// the transaction's change event is emitted once it's been replayed and has a sequence
+	s.pending.Store(txRec.UUID, changes(tx))
+
+	if opts.Consistency == LocalOnly {
+		// publishing continues after returning, so it must outlive the caller's context
+		pubCtx := context.WithoutCancel(ctx)
 
-	s.inflight.Store(txRec.UUID, cancel)
+		go func() {
+			ctx, cancel := s.withTimeout(pubCtx, opts)
+			defer cancel()
 
-	if err := s.replayer.Publish(txRec); err != nil {
-		return nil, errors.Wrapf(err, "failed to replayer.Publish for tx %s with name %s", txRec.UUID, txRec.Name)
+			if err := s.publish(ctx, txRec); err != nil {
+				s.log.Error(errors.Wrapf(err, "failed to publish local-only transaction %s with name %s", txRec.UUID, txRec.Name).Error())
+			}
+		}()
+
+		return result, nil
 	}
 
-	<-pubCtx.Done()
+	var waiter *ackWaiter
+
+	// the waiter must exist before publishing, as acknowledgements can arrive before the echo
+	if txRec.Ack {
+		waiter = newAckWaiter(opts, s.options.Region)
 
-	if err = pubCtx.Err(); err != nil {
-		if errors.Is(err, context.DeadlineExceeded) {
-			return nil, errors.Wrap(err, "publish context timed out")
+		s.waiters.Store(txRec.UUID, waiter)
+		defer s.waiters.Delete(txRec.UUID)
+	}
+
+	if err := s.publish(ctx, txRec); err != nil {
+		return nil, errors.Wrapf(err, "failed to publish transaction %s with name %s", txRec.UUID, txRec.Name)
+	}
+
+	if waiter != nil {
+		if err := waiter.wait(ctx); err != nil {
+			return nil, errors.Wrapf(err, "failed to replicate transaction %s with name %s", txRec.UUID, txRec.Name)
 		}
 	}
 
-	// the completionFunc above sets these when the two-stage transaction returns
 	return result, nil
 }
+
+// inflightTx is a local transaction that has been applied and is waiting to be replayed. Exec waits
+// until replayed is called, and once it stops waiting replayed is nil, but the transaction remains
+// in inflight so that it isn't applied again when it is replayed.
+type inflightTx struct {
+	replayed context.CancelCauseFunc
+}
+
+// publish publishes the transaction and waits for it to be replayed by this replica,
+// releasing execLock once it has been, or once publishing has failed or ctx is done
+func (s *Store) publish(ctx context.Context, rec TxRecord) error {
+	defer s.execLock.RUnlock()
+
+	// the replay loop calls replayed when the transaction is replayed
+	echoCtx, replayed := context.WithCancelCause(ctx)
+	defer replayed(nil)
+
+	tx := &inflightTx{replayed: replayed}
+
+	s.inflight.Store(rec.UUID, tx)
+
+	// refuse may have run before the transaction was stored, in which case it'll never be replayed
+	if err := s.outdatedErr(); err != nil {
+		s.inflight.Delete(rec.UUID)
+		s.pending.Delete(rec.UUID)
+		return err
+	}
+
+	// the transaction may have been published even if Publish failed, or is republished from the outbox,
+	// so once the caller stops waiting the replay loop must still know it's been applied when it's replayed
+	if err := s.replayer.Publish(ctx, rec); err != nil {
+		s.inflight.CompareAndSwap(rec.UUID, tx, &inflightTx{})
+		return errors.Wrap(err, "failed to replayer.Publish")
+	}
+
+	<-echoCtx.Done()
+
+	if err := ctx.Err(); err != nil {
+		s.inflight.CompareAndSwap(rec.UUID, tx, &inflightTx{})
+		return errors.Wrap(err, "gave up waiting for transaction to be replayed")
+	}
+
+	// replay stopped before reaching the transaction, see refuse
+	if err := context.Cause(echoCtx); !errors.Is(err, context.Canceled) {
+		return errors.Wrap(err, "replay stopped before transaction was replayed")
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrUnknownTransaction is returned by Exec and View, and closes subscriptions, once a transaction this replica
+// has no handler for has been replayed, such as a new version of a transaction during a rolling deploy
+var ErrUnknownTransaction = errors.New("stream contains a transaction unknown to this replica")
+
+// versionSeparator separates a transaction's name from its version, i.e. InsertPerson@v2
+const versionSeparator = "@v"
+
+// Version returns the TxName for the given version of the transaction, i.e. InsertPerson@v2
+func (n TxName) Version(version int) TxName {
+	base, _, _ := parseTxName(n)
+
+	return TxName(fmt.Sprintf("%s%s%d", base, versionSeparator, version))
+}
+
+// parseTxName splits a TxName into its base name and version, which is 0 if it isn't versioned
+func parseTxName(name TxName) (TxName, int, error) {
+	i := strings.LastIndex(string(name), versionSeparator)
+	if i < 0 {
+		return name, 0, nil
+	}
+
+	version, err := strconv.Atoi(string(name[i+len(versionSeparator):]))
+	if err != nil || version < 1 {
+		return name, 0, fmt.Errorf("transaction name %s has an invalid version, must be @v followed by a number from 1", name)
+	}
+
+	return name[:i], version, nil
+}
+
+// handler returns the handler registered for the given version of a transaction and that version,
+// or the latest version's if version is 0. Records from before versioning have version 0, and are
+// handled by version 1, which is also the version of handlers registered without one.
+func (s *Store) handler(name TxName, version int, latest bool) (TxHandler, int, error) {
+	versions, exists := s.transactions[name]
+	if !exists {
+		return nil, 0, fmt.Errorf("transaction with name %s is not registered", name)
+	}
+
+	if version == 0 && latest {
+		for v := range versions {
+			version = max(version, v)
+		}
+	}
+
+	version = max(version, 1)
+
+	handler, exists := versions[version]
+	if !exists {
+		return nil, 0, fmt.Errorf("transaction with name %s is not registered at version %d", name, version)
+	}
+
+	return handler, version, nil
+}
+
+// register registers the handler for the version of the transaction given in its name, or version 1
+func (s *Store) register(name TxName, handler TxHandler) error {
+	base, version, err := parseTxName(name)
+	if err != nil {
+		return errors.Wrap(err, "failed to parseTxName")
+	}
+
+	version = max(version, 1)
+
+	if _, exists := s.transactions[base]; !exists {
+		s.transactions[base] = map[int]TxHandler{}
+	}
+
+	if _, exists := s.transactions[base][version]; exists {
+		return fmt.Errorf("transaction registered with name %s at version %d already exists", base, version)
+	}
+
+	s.transactions[base][version] = handler
+
+	return nil
+}
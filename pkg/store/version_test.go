@@ -0,0 +1,50 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	fabricmem "github.com/cohix/libsdk/pkg/fabric/fabric-mem"
+	"github.com/cohix/libsdk/pkg/store"
+)
+
+func TestUnknownVersionRefused(t *testing.T) {
+	bus := fabricmem.NewBus()
+
+	old := startReplica(t, bus, nil)
+
+	upgraded := startReplica(t, bus, func(r *replica) {
+		r.handlers[insertPerson.Version(2)] = r.count(func(tx store.Tx, args ...any) (any, error) {
+			return tx.ReadWrite().Exec("INSERT INTO people (name) VALUES (upper($1))", args...)
+		})
+	})
+
+	if _, err := upgraded.Exec(insertPerson, "rick"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the old replica stops rather than skipping the transaction and serving data without it
+	eventually(t, func() bool {
+		_, err := old.Exec(appendEntry, "entry")
+		return errors.Is(err, store.ErrUnknownTransaction)
+	}, "Exec to return ErrUnknownTransaction")
+
+	names := []string{}
+	if err := old.Select(context.Background(), &names, "SELECT name FROM people"); !errors.Is(err, store.ErrUnknownTransaction) {
+		t.Fatalf("Select returned %v, want ErrUnknownTransaction", err)
+	}
+
+	letters, err := old.DeadLetters(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(letters) != 0 {
+		t.Fatalf("old replica dead lettered %+v, want none", letters)
+	}
+
+	if err := newReplica(t, bus).start(t); err == nil {
+		t.Fatal("replica started without a transaction version in the stream")
+	}
+}
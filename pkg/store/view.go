@@ -0,0 +1,45 @@
+package store
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// View runs fn in a read-only transaction against the local replica. Reads made with View
+// don't need to be registered as transactions, and are never published or replayed.
+func (s *Store) View(ctx context.Context, fn func(tx ReadTx) error) error {
+	viewer, ok := s.driver.(Viewer)
+	if !ok {
+		return errors.New("store driver does not support View")
+	}
+
+	if s.diverged.Load() {
+		return ErrDiverged
+	}
+
+	// replay has stopped, so the replica's data is out of date
+	if err := s.outdatedErr(); err != nil {
+		return err
+	}
+
+	if err := viewer.View(ctx, fn); err != nil {
+		return errors.Wrap(err, "failed to driver View")
+	}
+
+	return nil
+}
+
+// Get runs a query against the local replica to select a single row and read it into out.
+func (s *Store) Get(ctx context.Context, out any, query string, args ...any) error {
+	return s.View(ctx, func(tx ReadTx) error {
+		return tx.Get(out, query, args...)
+	})
+}
+
+// Select runs a query against the local replica to select one or more rows and read them into out.
+func (s *Store) Select(ctx context.Context, out any, query string, args ...any) error {
+	return s.View(ctx, func(tx ReadTx) error {
+		return tx.Select(out, query, args...)
+	})
+}
@@ -0,0 +1,42 @@
+package store_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	fabricmem "github.com/cohix/libsdk/pkg/fabric/fabric-mem"
+	"github.com/cohix/libsdk/pkg/store"
+)
+
+func TestViewReadOnly(t *testing.T) {
+	ctx := context.Background()
+
+	r := startReplica(t, fabricmem.NewBus(), nil)
+
+	if _, err := r.Exec(insertPerson, "rick"); err != nil {
+		t.Fatal(err)
+	}
+
+	// writes made by a view would never be replicated, so they're refused
+	writes := []string{
+		"INSERT INTO people (name) VALUES ('morty') RETURNING id",
+		"UPDATE people SET name = 'morty' RETURNING id",
+		"DELETE FROM people RETURNING id",
+	}
+
+	for _, query := range writes {
+		err := r.View(ctx, func(tx store.ReadTx) error {
+			id := 0
+			return tx.Get(&id, query)
+		})
+
+		if err == nil {
+			t.Fatalf("View ran %q", query)
+		}
+	}
+
+	if names := r.names(t); !slices.Equal(names, []string{"rick"}) {
+		t.Fatalf("replica has %v after views tried to write, want [rick]", names)
+	}
+}